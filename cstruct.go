@@ -27,6 +27,11 @@ type cStruct struct {
 	// readers-writer lock for dealing with mixing manual calls to Close() with
 	// GC.
 	lock sync.RWMutex
+
+	// gcClose, if set on a database, is called by the finalizers of the
+	// objects derived from it with their Close method, instead of calling it
+	// directly from the finalizer goroutine. See SafeDB.
+	gcClose func(func() error)
 }
 
 // Recursively acquire read locks on this object and all parent objects.
@@ -93,9 +98,51 @@ func (c *cStruct) live() bool {
 	return c.parent.live()
 }
 
+// Returns the last object in c's chain of parents. For every object derived
+// from a database, that is the database itself.
+func (c *cStruct) root() *cStruct {
+	for c.parent != nil {
+		c = c.parent
+	}
+	return c
+}
+
 // Set a finalizer to invoke c.Close() when c is garbage collected.
 func setGcClose(c io.Closer) {
 	runtime.SetFinalizer(c, func(c io.Closer) {
+		cs := asCStruct(c)
+		cs.rLock()
+		gcClose := cs.root().gcClose
+		cs.rUnlock()
+		if gcClose != nil {
+			gcClose(c.Close)
+			return
+		}
 		c.Close()
 	})
 }
+
+// asCStruct returns the cStruct of c, one of our wrapper types.
+func asCStruct(c io.Closer) *cStruct {
+	switch c := c.(type) {
+	case *DB:
+		return (*cStruct)(c)
+	case *Query:
+		return (*cStruct)(c)
+	case *Threads:
+		return (*cStruct)(c)
+	case *Thread:
+		return (*cStruct)(c)
+	case *Messages:
+		return (*cStruct)(c)
+	case *Message:
+		return (*cStruct)(c)
+	case *MessageProperties:
+		return (*cStruct)(c)
+	case *Tags:
+		return (*cStruct)(c)
+	case *ConfigList:
+		return (*cStruct)(c)
+	}
+	panic("notmuch: not a cStruct wrapper")
+}
@@ -10,6 +10,7 @@ package notmuch
 import "C"
 
 import (
+	"strings"
 	"unsafe"
 )
 
@@ -167,6 +168,16 @@ func (db *DB) Version() int {
 	return int(C.notmuch_database_get_version(db.toC()))
 }
 
+// Revision returns the current revision of the database and its UUID.
+// The revision is incremented by every change to the database; it is only
+// meaningful together with the UUID, which changes if the database is
+// recreated.
+func (db *DB) Revision() (uint64, string) {
+	var cuuid *C.char
+	rev := C.notmuch_database_get_revision(db.toC(), &cuuid)
+	return uint64(rev), C.GoString(cuuid)
+}
+
 // LastStatus retrieves last status string for the notmuch database.
 func (db *DB) LastStatus() string {
 	return C.GoString(C.notmuch_database_status_string(db.toC()))
@@ -261,6 +272,84 @@ func (db *DB) FindMessageByFilename(filename string) (*Message, error) {
 	return msg, nil
 }
 
+const (
+	// findMessagesBatchMin is the number of IDs from which FindMessages
+	// looks messages up with a single id: query rather than one
+	// FindMessage call per ID.
+	findMessagesBatchMin = 8
+
+	// findMessagesBatchMax is the largest number of IDs FindMessages puts
+	// in a single query.
+	findMessagesBatchMax = 512
+)
+
+// FindMessages finds the messages with the given message IDs. The IDs may
+// be in any of the forms accepted by NormalizeMessageID. It returns the
+// messages found, in the order of ids, and the normalized IDs of the
+// messages which were not found. Duplicate IDs are looked up only once.
+func (db *DB) FindMessages(ids []string) ([]*Message, []string, error) {
+	var normalized []string
+	seen := map[string]bool{}
+	for _, id := range ids {
+		id = NormalizeMessageID(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		normalized = append(normalized, id)
+	}
+
+	byID := make(map[string]*Message, len(normalized))
+	if len(normalized) < findMessagesBatchMin {
+		for _, id := range normalized {
+			msg, err := db.FindMessage(id)
+			if err == ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			// Queries leave ghost messages out: do the same.
+			if msg.Flag(MessageFlagGhost) {
+				continue
+			}
+			byID[id] = msg
+		}
+	} else {
+		for start := 0; start < len(normalized); start += findMessagesBatchMax {
+			end := start + findMessagesBatchMax
+			if end > len(normalized) {
+				end = len(normalized)
+			}
+			terms := make([]string, 0, end-start)
+			for _, id := range normalized[start:end] {
+				terms = append(terms, booleanTerm("id", id))
+			}
+			query := db.NewQuery(strings.Join(terms, " or "))
+			query.SetSortScheme(SORT_UNSORTED)
+			msgs, err := query.Messages()
+			if err != nil {
+				return nil, nil, err
+			}
+			var msg *Message
+			for msgs.Next(&msg) {
+				byID[msg.ID()] = msg
+			}
+		}
+	}
+
+	var found []*Message
+	var missing []string
+	for _, id := range normalized {
+		if msg, ok := byID[id]; ok {
+			found = append(found, msg)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	return found, missing, nil
+}
+
 // Tags returns the list of all tags in the database.
 func (db *DB) Tags() (*Tags, error) {
 	ctags := C.notmuch_database_get_all_tags(db.toC())
@@ -308,6 +397,22 @@ func (db *DB) GetConfig(key string) (string, error) {
 	return C.GoString(cval), nil
 }
 
+// configValues returns the values of a list-valued config key, such as
+// user.other_email, which notmuch stores separated by semicolons.
+func (db *DB) configValues(key string) ([]string, error) {
+	value, err := db.GetConfig(key)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, v := range strings.Split(value, ";") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
 // SetConfig sets config key to value.
 func (db *DB) SetConfig(key, value string) error {
 	ckey := C.CString(key)
@@ -0,0 +1,247 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/zenhack/go.notmuch/maildir"
+)
+
+// DuplicateFile is one of the files of a message with several.
+type DuplicateFile struct {
+	Path string
+
+	// Folder is the folder of the file relative to the mail root, without
+	// the maildir new or cur subdirectory, as in notmuch's folder: search
+	// terms.
+	Folder string
+
+	Size    int64
+	ModTime time.Time
+
+	// Identical is true if the file has the same content as the first
+	// file of the message.
+	Identical bool
+
+	sum []byte
+}
+
+// DuplicateSet lists the files of a message with more than one.
+type DuplicateSet struct {
+	MessageID string
+	Files     []*DuplicateFile
+
+	// Identical is true if all the files have the same content, false if
+	// they only share their Message-ID.
+	Identical bool
+}
+
+// Duplicates returns the messages matching query which have more than one
+// file, in message ID order. An empty query checks all messages.
+func (db *DB) Duplicates(query string) ([]*DuplicateSet, error) {
+	q := db.NewQuery(query)
+	q.SetExcludeScheme(EXCLUDE_FALSE)
+	q.SetSortScheme(SORT_MESSAGE_ID)
+	msgs, err := q.Messages()
+	if err != nil {
+		return nil, err
+	}
+	root := db.mailRoot()
+	var sets []*DuplicateSet
+	var msg *Message
+	for msgs.Next(&msg) {
+		names := msg.Filenames().slice()
+		if len(names) < 2 {
+			continue
+		}
+		set := &DuplicateSet{MessageID: msg.ID(), Identical: true}
+		for _, name := range names {
+			file, err := statDuplicate(root, name)
+			if err != nil {
+				return sets, err
+			}
+			file.Identical = len(set.Files) == 0 || bytes.Equal(file.sum, set.Files[0].sum)
+			set.Identical = set.Identical && file.Identical
+			set.Files = append(set.Files, file)
+		}
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+func statDuplicate(root, path string) (*DuplicateFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(path)
+	if sub := filepath.Base(dir); sub == maildir.New || sub == maildir.Cur {
+		dir = filepath.Dir(dir)
+	}
+	folder, err := filepath.Rel(root, dir)
+	if err != nil {
+		folder = dir
+	} else if folder == "." {
+		folder = ""
+	}
+	return &DuplicateFile{
+		Path:    path,
+		Folder:  folder,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		sum:     h.Sum(nil),
+	}, nil
+}
+
+// DedupeKeep chooses which file of a message to keep, among those equally
+// preferred by DedupeOptions.Folders.
+type DedupeKeep int
+
+const (
+	// KeepOldest keeps the file modified first.
+	KeepOldest DedupeKeep = iota
+
+	// KeepLargest keeps the largest file.
+	KeepLargest
+)
+
+// DedupeOptions controls the behaviour of DB.Dedupe.
+type DedupeOptions struct {
+	// Folders lists folders, as in DuplicateFile.Folder, by order of
+	// preference: a file in a folder listed first is kept over the others.
+	Folders []string
+
+	Keep DedupeKeep
+
+	// IncludeDifferent also removes files whose content differs from the
+	// kept one. By default, only byte-identical copies are removed.
+	IncludeDifferent bool
+
+	// DryRun reports what would be removed without removing anything.
+	DryRun bool
+}
+
+// DedupeReport summarizes the outcome of DB.Dedupe.
+type DedupeReport struct {
+	Kept    []string
+	Removed []string
+
+	// Failed lists the files removed from the database which could not be
+	// unlinked.
+	Failed []string
+
+	// Freed is the total size of the files removed.
+	Freed int64
+}
+
+// Dedupe keeps a single file of each message matching query with several,
+// chosen according to opts, and removes the others from the database and
+// from the disk. Files are removed from the database inside an atomic
+// section, before being unlinked; the files which cannot be unlinked are
+// reported as failed, along with the first error. opts may be nil.
+func (db *DB) Dedupe(query string, opts *DedupeOptions) (*DedupeReport, error) {
+	if opts == nil {
+		opts = &DedupeOptions{}
+	}
+	sets, err := db.Duplicates(query)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DedupeReport{}
+	var remove []*DuplicateFile
+	for _, set := range sets {
+		keep := chooseDuplicate(set.Files, opts)
+		report.Kept = append(report.Kept, keep.Path)
+		for _, file := range set.Files {
+			if file == keep || !opts.IncludeDifferent && !bytes.Equal(file.sum, keep.sum) {
+				continue
+			}
+			remove = append(remove, file)
+		}
+	}
+	if opts.DryRun {
+		for _, file := range remove {
+			report.Removed = append(report.Removed, file.Path)
+			report.Freed += file.Size
+		}
+		return report, nil
+	}
+
+	var removeErr error
+	dropped := 0
+	err = db.Atomic(func(db *DB) {
+		for _, file := range remove {
+			// The message keeps another file, so notmuch reports
+			// ErrDuplicateMessageID on success.
+			if err := db.RemoveMessage(file.Path); err != nil && err != ErrDuplicateMessageID {
+				removeErr = err
+				return
+			}
+			dropped++
+		}
+	})
+	if err != nil {
+		// The atomic section may not be committed: the files are
+		// possibly still in the database, so keep them.
+		if removeErr == nil {
+			removeErr = err
+		}
+		return report, removeErr
+	}
+	// Unlink whatever is out of the database, even on error.
+	for _, file := range remove[:dropped] {
+		if err := os.Remove(file.Path); err != nil {
+			if removeErr == nil {
+				removeErr = err
+			}
+			report.Failed = append(report.Failed, file.Path)
+			continue
+		}
+		report.Removed = append(report.Removed, file.Path)
+		report.Freed += file.Size
+	}
+	return report, removeErr
+}
+
+// chooseDuplicate returns the file to keep among files.
+func chooseDuplicate(files []*DuplicateFile, opts *DedupeOptions) *DuplicateFile {
+	rank := func(f *DuplicateFile) int {
+		for i, folder := range opts.Folders {
+			if filepath.Clean(folder) == filepath.Clean(f.Folder) {
+				return i
+			}
+		}
+		return len(opts.Folders)
+	}
+	sorted := append([]*DuplicateFile(nil), files...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if ra, rb := rank(a), rank(b); ra != rb {
+			return ra < rb
+		}
+		if opts.Keep == KeepLargest && a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		return a.ModTime.Before(b.ModTime)
+	})
+	return sorted[0]
+}
@@ -0,0 +1,146 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChooseDuplicate(t *testing.T) {
+	now := time.Now()
+	files := []*DuplicateFile{
+		{Path: "a", Folder: "lists/go", Size: 10, ModTime: now},
+		{Path: "b", Folder: "inbox", Size: 20, ModTime: now.Add(time.Hour)},
+		{Path: "c", Folder: "archive", Size: 5, ModTime: now.Add(-time.Hour)},
+	}
+	tests := []struct {
+		opts DedupeOptions
+		want string
+	}{
+		{DedupeOptions{}, "c"},
+		{DedupeOptions{Keep: KeepLargest}, "b"},
+		{DedupeOptions{Folders: []string{"lists/go/"}}, "a"},
+		{DedupeOptions{Folders: []string{"nowhere", "inbox"}, Keep: KeepOldest}, "b"},
+	}
+	for _, tt := range tests {
+		if got := chooseDuplicate(files, &tt.opts); tt.want != got.Path {
+			t.Errorf("chooseDuplicate(%+v): want %s got %s", tt.opts, tt.want, got.Path)
+		}
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	db, err := Open(dbPath, DBReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	defer os.RemoveAll(filepath.Join(db.mailRoot(), "go-notmuch-dedupe"))
+	opts := &InsertOptions{CreateFolder: true}
+	var paths []string
+	for _, folder := range []string{"a", "b"} {
+		res, err := db.Insert(strings.NewReader(testInsertMessage), "go-notmuch-dedupe/"+folder, "", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, res.Path)
+		defer db.RemoveMessage(res.Path)
+	}
+	res, err := db.Insert(strings.NewReader(testInsertMessage+"Changed.\n"), "go-notmuch-dedupe/c", "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.RemoveMessage(res.Path)
+
+	qs := "id:go-notmuch-insert@example.com"
+	sets, err := db.Duplicates(qs)
+	if err != nil {
+		t.Fatalf("db.Duplicates(): unexpected error: %s", err)
+	}
+	if len(sets) != 1 || len(sets[0].Files) != 3 {
+		t.Fatalf("db.Duplicates(): want 1 message with 3 files got %+v", sets)
+	}
+	if sets[0].Identical {
+		t.Errorf("db.Duplicates(): want files reported different got identical")
+	}
+	// Identical compares to the first file, whichever notmuch lists first.
+	byPath := map[string]*DuplicateFile{}
+	for _, file := range sets[0].Files {
+		byPath[file.Path] = file
+	}
+	a, b, changed := byPath[paths[0]], byPath[paths[1]], byPath[res.Path]
+	if a == nil || b == nil || changed == nil {
+		t.Fatalf("db.Duplicates(): want files %s, %s and %s got %+v", paths[0], paths[1], res.Path, sets[0].Files)
+	}
+	if a.Identical != b.Identical || a.Identical == changed.Identical {
+		t.Errorf("db.Duplicates(): want %s and %s alike and %s different got Identical %v, %v and %v",
+			paths[0], paths[1], res.Path, a.Identical, b.Identical, changed.Identical)
+	}
+
+	report, err := db.Dedupe(qs, &DedupeOptions{Folders: []string{"go-notmuch-dedupe/b"}})
+	if err != nil {
+		t.Fatalf("db.Dedupe(): unexpected error: %s", err)
+	}
+	want := &DedupeReport{Kept: []string{paths[1]}, Removed: []string{paths[0]}, Freed: int64(len(testInsertMessage))}
+	if !reflect.DeepEqual(want, report) {
+		t.Errorf("db.Dedupe(): want %+v got %+v", want, report)
+	}
+	if _, err := os.Stat(paths[0]); !os.IsNotExist(err) {
+		t.Errorf("db.Dedupe(): want %s removed got error %v", paths[0], err)
+	}
+	msg, err := db.FindMessage("go-notmuch-insert@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(msg.Filenames().slice()); want != got {
+		t.Errorf("filenames after Dedupe: want %d got %d", want, got)
+	}
+}
+
+func TestDedupeUnremovable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can remove files from read-only directories")
+	}
+	db, err := Open(dbPath, DBReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	defer os.RemoveAll(filepath.Join(db.mailRoot(), "go-notmuch-dedupe"))
+	opts := &InsertOptions{CreateFolder: true}
+	var paths []string
+	for _, folder := range []string{"a", "b"} {
+		res, err := db.Insert(strings.NewReader(testInsertMessage), "go-notmuch-dedupe/"+folder, "", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, res.Path)
+		defer db.RemoveMessage(res.Path)
+	}
+
+	dir := filepath.Dir(paths[0])
+	if err := os.Chmod(dir, 0500); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0700)
+	report, err := db.Dedupe("id:go-notmuch-insert@example.com", &DedupeOptions{Folders: []string{"go-notmuch-dedupe/b"}})
+	if err == nil {
+		t.Errorf("db.Dedupe(read-only file): want an error got nil")
+	}
+	want := &DedupeReport{Kept: []string{paths[1]}, Failed: []string{paths[0]}}
+	if !reflect.DeepEqual(want, report) {
+		t.Errorf("db.Dedupe(read-only file): want %+v got %+v", want, report)
+	}
+	if _, err := os.Stat(paths[0]); err != nil {
+		t.Errorf("db.Dedupe(read-only file): want %s left on disk got error %v", paths[0], err)
+	}
+}
@@ -0,0 +1,371 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+// This file implements the batch-tag format of `notmuch dump` and
+// `notmuch restore`.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// The first line of a dump, identifying its format and what it includes.
+const (
+	dumpHeader           = "#notmuch-dump batch-tag:3 tags\n"
+	dumpHeaderProperties = "#notmuch-dump batch-tag:3 properties,tags\n"
+)
+
+// DumpOptions controls the output of DB.Dump.
+type DumpOptions struct {
+	// Properties includes message properties in the dump, like
+	// `notmuch dump --include=properties,tags`.
+	Properties bool
+}
+
+// RestoreOptions controls the behaviour of DB.Restore.
+type RestoreOptions struct {
+	// Accumulate adds the tags and properties in the dump to those
+	// messages already have, like `notmuch restore --accumulate`. By
+	// default, the tags of each message in the dump are replaced with those
+	// in the dump, and so are its properties if the dump has any for it.
+	Accumulate bool
+}
+
+// RestoreReport summarizes the outcome of DB.Restore.
+type RestoreReport struct {
+	// Messages is the number of messages in the dump which were found in
+	// the database.
+	Messages int
+
+	// Changed is the number of messages whose tags were changed.
+	Changed int
+
+	// Missing lists the IDs in the dump which are not in the database,
+	// including those only known as ghost messages. Their lines are
+	// skipped.
+	Missing []string
+}
+
+// Dump writes the tags of every message matching query to w, in the
+// batch-tag format of `notmuch dump`: one line per message, in message ID
+// order, of the form
+//
+//	+tag1 +tag2 -- id:message-id
+//
+// with tags and message IDs hex-encoded. An empty query dumps the whole
+// database. opts may be nil.
+//
+// If opts.Properties is set, each message with properties also gets a line
+// of the form
+//
+//	#= message-id key1=value1 key2=value2
+//
+// with message ID, keys and values hex-encoded, before its tags.
+func (db *DB) Dump(w io.Writer, query string, opts *DumpOptions) error {
+	if opts == nil {
+		opts = &DumpOptions{}
+	}
+	q := db.NewQuery(query)
+	q.SetSortScheme(SORT_MESSAGE_ID)
+	q.SetExcludeScheme(EXCLUDE_FALSE)
+	msgs, err := q.Messages()
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	if opts.Properties {
+		bw.WriteString(dumpHeaderProperties)
+	} else {
+		bw.WriteString(dumpHeader)
+	}
+	var msg *Message
+	for msgs.Next(&msg) {
+		id := msg.ID()
+		if strings.ContainsAny(id, "\r\n") {
+			// Message IDs come from unfolded headers, so this can't
+			// happen; if it does, the line could not be parsed back.
+			continue
+		}
+		if opts.Properties {
+			writeDumpProperties(bw, msg)
+		}
+		tags := msg.Tags().slice()
+		for i, tag := range tags {
+			tags[i] = "+" + hexEncode(tag)
+		}
+		bw.WriteString(strings.Join(tags, " "))
+		bw.WriteString(" -- id:" + hexEncode(id) + "\n")
+	}
+	return bw.Flush()
+}
+
+// writeDumpProperties writes the "#=" line holding the properties of msg,
+// if it has any.
+func writeDumpProperties(w *bufio.Writer, msg *Message) {
+	props := msg.Properties("", false)
+	var prop *MessageProperty
+	first := true
+	for props.Next(&prop) {
+		if first {
+			w.WriteString("#= " + hexEncode(msg.ID()))
+			first = false
+		}
+		w.WriteString(" " + hexEncode(prop.Key) + "=" + hexEncode(prop.Value))
+	}
+	if !first {
+		w.WriteString("\n")
+	}
+}
+
+// Restore reads a dump in the batch-tag format of `notmuch dump` from r and
+// applies the tags and properties in it. Lines for messages which are not in
+// the database are skipped, and their IDs reported. opts may be nil.
+//
+// The dump is processed a line at a time, so it is never held in memory as
+// a whole.
+func (db *DB) Restore(r io.Reader, opts *RestoreOptions) (*RestoreReport, error) {
+	if opts == nil {
+		opts = &RestoreOptions{}
+	}
+	report := &RestoreReport{}
+	br := bufio.NewReader(r)
+	for lineno := 1; ; lineno++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return report, err
+		}
+		if restoreErr := db.restoreLine(line, opts, report); restoreErr != nil {
+			return report, fmt.Errorf("line %d: %s", lineno, restoreErr)
+		}
+		if err == io.EOF {
+			return report, nil
+		}
+	}
+}
+
+func (db *DB) restoreLine(line string, opts *RestoreOptions, report *RestoreReport) error {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "#=") {
+		return db.restoreProperties(line[len("#="):], opts)
+	}
+	if line == "" || line[0] == '#' {
+		return nil
+	}
+	ops, id, err := parseDumpLine(line)
+	if err != nil {
+		return err
+	}
+	msg, err := db.FindMessage(id)
+	if err == ErrNotFound || err == nil && msg.Flag(MessageFlagGhost) {
+		report.Missing = append(report.Missing, id)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	report.Messages++
+
+	want := map[string]bool{}
+	if opts.Accumulate {
+		for _, tag := range msg.Tags().slice() {
+			want[tag] = true
+		}
+	}
+	for _, op := range ops {
+		want[op.Tag] = !op.Remove
+	}
+	var tags []string
+	for tag, ok := range want {
+		if ok {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	change, err := msg.SetTags(tags, nil)
+	if err != nil {
+		return err
+	}
+	if change.Changed() {
+		report.Changed++
+	}
+	return nil
+}
+
+// restoreProperties applies a "#=" line of a dump. Missing messages are
+// skipped silently; they are reported when their tag line comes up.
+func (db *DB) restoreProperties(line string, opts *RestoreOptions) error {
+	id, props, err := parseDumpProperties(line)
+	if err != nil {
+		return err
+	}
+	msg, err := db.FindMessage(id)
+	if err == ErrNotFound || err == nil && msg.Flag(MessageFlagGhost) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	atomicErr := msg.Atomic(func(msg *Message) {
+		if !opts.Accumulate {
+			if err = msg.removeAllProperties(); err != nil {
+				return
+			}
+		}
+		for _, prop := range props {
+			if err = msg.AddProperty(prop.Key, prop.Value); err != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return atomicErr
+}
+
+// parseDumpProperties parses what follows the "#=" of a property line into
+// the message ID and its properties.
+func parseDumpProperties(line string) (string, []*MessageProperty, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", nil, fmt.Errorf("missing message id in property line")
+	}
+	id, err := hexDecode(fields[0])
+	if err != nil {
+		return "", nil, err
+	}
+	var props []*MessageProperty
+	for _, field := range fields[1:] {
+		i := strings.IndexByte(field, '=')
+		if i <= 0 {
+			return "", nil, fmt.Errorf("invalid property %q, want key=value", field)
+		}
+		key, err := hexDecode(field[:i])
+		if err != nil {
+			return "", nil, err
+		}
+		value, err := hexDecode(field[i+1:])
+		if err != nil {
+			return "", nil, err
+		}
+		props = append(props, &MessageProperty{Key: key, Value: value})
+	}
+	return id, props, nil
+}
+
+// parseDumpLine parses a line of a batch-tag dump into its tag operations
+// and message ID. As in notmuch, the "--" separating the tags from the
+// query may be left out.
+func parseDumpLine(line string) ([]TagOp, string, error) {
+	var ops []TagOp
+	rest := strings.TrimSpace(line)
+	for rest != "" {
+		field := rest
+		if i := strings.IndexAny(rest, " \t"); i >= 0 {
+			field = rest[:i]
+		}
+		if field[0] != '+' && field[0] != '-' {
+			break
+		}
+		rest = strings.TrimSpace(rest[len(field):])
+		if field == "--" {
+			break
+		}
+		tag, err := hexDecode(field[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if tag == "" {
+			return nil, "", ErrInvalidTagOp
+		}
+		ops = append(ops, TagOp{Tag: tag, Remove: field[0] == '-'})
+	}
+
+	if !hasPrefixFold(rest, "id:") {
+		return nil, "", fmt.Errorf("unsupported query %q, want id:<message-id>", rest)
+	}
+	term := rest[len("id:"):]
+	quoted := strings.HasPrefix(term, `"`) && strings.HasSuffix(term, `"`) && len(term) >= 2
+	if !quoted && strings.ContainsAny(term, " \t") {
+		return nil, "", fmt.Errorf("unsupported query %q, want id:<message-id>", rest)
+	}
+	id, err := hexDecode(unquoteID(term))
+	if err != nil {
+		return nil, "", err
+	}
+	return ops, id, nil
+}
+
+// hexSafe reports whether c may appear unescaped in hex-encoded text.
+func hexSafe(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		strings.IndexByte("+-_@=.,", c) >= 0
+}
+
+// hexEncode escapes s the way notmuch's hex_encode does: every byte outside
+// [A-Za-z0-9+-_@=.,] is replaced with %xx.
+func hexEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; hexSafe(c) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02x", c)
+		}
+	}
+	return b.String()
+}
+
+// hexDecode undoes hexEncode.
+func hexDecode(s string) (string, error) {
+	if strings.IndexByte(s, '%') < 0 {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		var c byte
+		if i+2 >= len(s) || !unhex(s[i+1], &c) || !unhex(s[i+2], &c) {
+			return "", fmt.Errorf("invalid hex escape in %q", s)
+		}
+		b.WriteByte(c)
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// unhex shifts the value of the hex digit d into the low bits of c.
+func unhex(d byte, c *byte) bool {
+	switch {
+	case '0' <= d && d <= '9':
+		d -= '0'
+	case 'a' <= d && d <= 'f':
+		d -= 'a' - 10
+	case 'A' <= d && d <= 'F':
+		d -= 'A' - 10
+	default:
+		return false
+	}
+	*c = *c<<4 | d
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHexEncode(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"inbox", "inbox"},
+		{"a-b_c@d=e.f,g+h", "a-b_c@d=e.f,g+h"},
+		{"to do", "to%20do"},
+		{"100%", "100%25"},
+		{"café", "caf%c3%a9"},
+	}
+	for _, tt := range tests {
+		got := hexEncode(tt.in)
+		if tt.want != got {
+			t.Errorf("hexEncode(%q): want %q got %q", tt.in, tt.want, got)
+		}
+		if back, err := hexDecode(got); err != nil || back != tt.in {
+			t.Errorf("hexDecode(%q): want %q got %q (error %v)", got, tt.in, back, err)
+		}
+	}
+	for _, bad := range []string{"%", "%2", "%zz"} {
+		if _, err := hexDecode(bad); err == nil {
+			t.Errorf("hexDecode(%q): want an error got nil", bad)
+		}
+	}
+}
+
+func TestParseDumpLine(t *testing.T) {
+	tests := []struct {
+		line string
+		ops  []TagOp
+		id   string
+	}{
+		{"+inbox +to%20do -- id:a@example.com", []TagOp{{Tag: "inbox"}, {Tag: "to do"}}, "a@example.com"},
+		{"-- id:a%20b@example.com", nil, "a b@example.com"},
+		{`+inbox -unread id:"a b@example.com"`, []TagOp{{Tag: "inbox"}, {Tag: "unread", Remove: true}}, "a b@example.com"},
+	}
+	for _, tt := range tests {
+		ops, id, err := parseDumpLine(tt.line)
+		if err != nil {
+			t.Errorf("parseDumpLine(%q): unexpected error: %s", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(tt.ops, ops) || tt.id != id {
+			t.Errorf("parseDumpLine(%q): want %v %q got %v %q", tt.line, tt.ops, tt.id, ops, id)
+		}
+	}
+	for _, bad := range []string{"+inbox", "+inbox -- tag:foo", "+inbox -- id:a id:b", "+%zz -- id:a"} {
+		if _, _, err := parseDumpLine(bad); err == nil {
+			t.Errorf("parseDumpLine(%q): want an error got nil", bad)
+		}
+	}
+}
+
+func TestDumpRestore(t *testing.T) {
+	db, err := Open(dbPath, DBReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	qs := "id:1258471718-6781-2-git-send-email-dottedmag@dottedmag.net"
+	var dump bytes.Buffer
+	if err := db.Dump(&dump, qs, nil); err != nil {
+		t.Fatalf("db.Dump(): unexpected error: %s", err)
+	}
+	want := dumpHeader + "+inbox +unread -- id:1258471718-6781-2-git-send-email-dottedmag@dottedmag.net\n"
+	if got := dump.String(); want != got {
+		t.Fatalf("db.Dump(): want %q got %q", want, got)
+	}
+
+	msg, err := db.FindMessage("1258471718-6781-2-git-send-email-dottedmag@dottedmag.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.AddTag("go-notmuch-test")
+	msg.RemoveTag("unread")
+
+	input := dump.String() + "# a comment\n\n+inbox -- id:notfound@example.com\n"
+	report, err := db.Restore(strings.NewReader(input), nil)
+	if err != nil {
+		t.Fatalf("db.Restore(): unexpected error: %s", err)
+	}
+	if want, got := (RestoreReport{Messages: 1, Changed: 1, Missing: []string{"notfound@example.com"}}), *report; !reflect.DeepEqual(want, got) {
+		t.Errorf("db.Restore(): want report %+v got %+v", want, got)
+	}
+	if want, got := []string{"inbox", "unread"}, msg.Tags().slice(); !reflect.DeepEqual(want, got) {
+		t.Errorf("msg.Tags() after restore: want %v got %v", want, got)
+	}
+
+	report, err = db.Restore(strings.NewReader("+go-notmuch-test -- id:"+msg.ID()), &RestoreOptions{Accumulate: true})
+	if err != nil {
+		t.Fatalf("db.Restore(accumulate): unexpected error: %s", err)
+	}
+	defer msg.RemoveTag("go-notmuch-test")
+	if want, got := []string{"go-notmuch-test", "inbox", "unread"}, msg.Tags().slice(); !reflect.DeepEqual(want, got) {
+		t.Errorf("msg.Tags() after accumulating restore: want %v got %v", want, got)
+	}
+
+	// Ghost messages are only referenced, not in the database.
+	var ghost string
+	missing, err := db.MissingMessages("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mm := range missing {
+		if mm.Ghost {
+			ghost = mm.ID
+			break
+		}
+	}
+	if ghost == "" {
+		t.Fatal("db.MissingMessages(): want a ghost message in the test database got none")
+	}
+	input = "#= " + hexEncode(ghost) + " go-notmuch-key=value\n+go-notmuch-test -- id:" + hexEncode(ghost) + "\n"
+	report, err = db.Restore(strings.NewReader(input), nil)
+	if err != nil {
+		t.Fatalf("db.Restore(ghost): unexpected error: %s", err)
+	}
+	if want, got := (RestoreReport{Missing: []string{ghost}}), *report; !reflect.DeepEqual(want, got) {
+		t.Errorf("db.Restore(ghost): want report %+v got %+v", want, got)
+	}
+
+	if _, err := db.Restore(strings.NewReader("+inbox -- from:alice\n"), nil); err == nil {
+		t.Errorf("db.Restore(bad query): want an error got nil")
+	}
+}
+
+func TestParseDumpProperties(t *testing.T) {
+	id, props, err := parseDumpProperties(" a%20b@example.com session-key=1%3aabc x.state=")
+	if err != nil {
+		t.Fatalf("parseDumpProperties(): unexpected error: %s", err)
+	}
+	if want := "a b@example.com"; want != id {
+		t.Errorf("parseDumpProperties(): want id %q got %q", want, id)
+	}
+	want := []*MessageProperty{{Key: "session-key", Value: "1:abc"}, {Key: "x.state", Value: ""}}
+	if !reflect.DeepEqual(want, props) {
+		t.Errorf("parseDumpProperties(): want %v got %v", want, props)
+	}
+	for _, bad := range []string{"", "id novalue", "id =value", "id k=%zz"} {
+		if _, _, err := parseDumpProperties(bad); err == nil {
+			t.Errorf("parseDumpProperties(%q): want an error got nil", bad)
+		}
+	}
+}
+
+func TestDumpRestoreProperties(t *testing.T) {
+	db, err := Open(dbPath, DBReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	id := "1258471718-6781-2-git-send-email-dottedmag@dottedmag.net"
+	msg, err := db.FindMessage(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.AddProperty("go-notmuch-test", "a b"); err != nil {
+		t.Fatalf("msg.AddProperty(): unexpected error: %s", err)
+	}
+	defer msg.RemoveAllProperties("go-notmuch-test")
+
+	var dump bytes.Buffer
+	if err := db.Dump(&dump, "id:"+id, &DumpOptions{Properties: true}); err != nil {
+		t.Fatalf("db.Dump(): unexpected error: %s", err)
+	}
+	want := dumpHeaderProperties +
+		"#= " + id + " go-notmuch-test=a%20b\n" +
+		"+inbox +unread -- id:" + id + "\n"
+	if got := dump.String(); want != got {
+		t.Fatalf("db.Dump(): want %q got %q", want, got)
+	}
+
+	if err := msg.AddProperty("go-notmuch-test", "stale"); err != nil {
+		t.Fatalf("msg.AddProperty(): unexpected error: %s", err)
+	}
+	if _, err := db.Restore(&dump, nil); err != nil {
+		t.Fatalf("db.Restore(): unexpected error: %s", err)
+	}
+	if want, got := []string{"a b"}, msg.Properties("go-notmuch-test", true).slice(); !reflect.DeepEqual(want, got) {
+		t.Errorf("msg.Properties() after restore: want %v got %v", want, got)
+	}
+}
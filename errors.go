@@ -74,6 +74,38 @@ var (
 	// ErrNoRepliesOrPointerNotFromThread is returned if a message has no replies or if the message's C
 	// pointer did not come from a thread.
 	ErrNoRepliesOrPointerNotFromThread = errors.New("message has no replies or message's pointer not from a thread")
+
+	// ErrInvalidTagOp is returned when a tag operation is neither of the form
+	// +tag nor -tag.
+	ErrInvalidTagOp = errors.New("invalid tag operation")
+
+	// ErrJournalConflict is returned by Journal.Undo when the tags of a
+	// message changed since the operation to undo.
+	ErrJournalConflict = errors.New("tags changed since the journaled operation")
+
+	// ErrTagEmpty is returned by tag validation for empty tags.
+	ErrTagEmpty = errors.New("empty tag")
+
+	// ErrTagLeadingSign is returned by tag validation for tags starting
+	// with '+' or '-', which read as tag operations.
+	ErrTagLeadingSign = errors.New("tag starts with '+' or '-'")
+
+	// ErrTagInvalidChar is returned by tag validation for tags holding
+	// whitespace, control characters, invalid UTF-8 or characters a
+	// TagPolicy does not allow.
+	ErrTagInvalidChar = errors.New("invalid character in tag")
+
+	// ErrTagCase is returned by tag validation for tags with upper case
+	// letters when a TagPolicy requires lower case.
+	ErrTagCase = errors.New("tag is not lower case")
+
+	// ErrFlagSyncDisabled is returned when fixing maildir flags while
+	// maildir.synchronize_flags is not enabled.
+	ErrFlagSyncDisabled = errors.New("maildir.synchronize_flags is not enabled")
+
+	// ErrDBClosed is returned by SafeDB methods once the database is
+	// closed.
+	ErrDBClosed = errors.New("database is closed")
 )
 
 // Notmuch returns NULL in several instances on out of memory errors. The
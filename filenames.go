@@ -26,6 +26,16 @@ func (fs *Filenames) Next(f *string) bool {
 	return true
 }
 
+// Return a slice of strings containing each element of fs.
+func (fs *Filenames) slice() []string {
+	var f string
+	ret := []string{}
+	for fs.Next(&f) {
+		ret = append(ret, f)
+	}
+	return ret
+}
+
 func (fs *Filenames) get() string {
 	return C.GoString(C.notmuch_filenames_get(fs.cptr))
 }
@@ -0,0 +1,196 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zenhack/go.notmuch/maildir"
+)
+
+// InsertOptions controls the behaviour of DB.Insert.
+type InsertOptions struct {
+	TagOptions
+
+	// CreateFolder creates the folder, as a maildir, if it does not
+	// exist.
+	CreateFolder bool
+}
+
+// InsertResult describes a message delivered by DB.Insert.
+type InsertResult struct {
+	Message *Message
+
+	// Path is the path of the file written.
+	Path string
+
+	// Duplicate is true if the database already had a message with the
+	// same ID. The file was added to that message as another filename.
+	Duplicate bool
+}
+
+// Insert delivers the message read from r to the maildir folder, relative
+// to the mail root, and indexes it, like `notmuch insert`. An empty folder
+// is the mail root itself. opts may be nil.
+//
+// A new message gets the tags in new.tags, then the tag operations in ops,
+// in the syntax accepted by ParseTagOps. A duplicate of a message already
+// in the database only gets ops applied. If opts.SyncMaildirFlags or
+// maildir.synchronize_flags is set, the maildir flags of the message are
+// turned into tags before ops are applied, and the tags back into flags
+// after, like `notmuch insert` does. With opts.Strict, the tags are
+// validated before anything is written.
+//
+// If anything fails once the file is written, the file is removed from the
+// database and from the disk. Tag changes to a duplicate cannot be rolled
+// back though.
+func (db *DB) Insert(r io.Reader, folder string, ops string, opts *InsertOptions) (*InsertResult, error) {
+	if opts == nil {
+		opts = &InsertOptions{}
+	}
+	parsed, err := ParseTagOps(ops)
+	if err != nil {
+		return nil, err
+	}
+	tagOpts := opts.TagOptions
+	if !tagOpts.SyncMaildirFlags {
+		if tagOpts.SyncMaildirFlags, err = db.configBool("maildir.synchronize_flags"); err != nil {
+			return nil, err
+		}
+	}
+	newTags, err := db.configValues("new.tags")
+	if err != nil {
+		return nil, err
+	}
+	withNew := make([]TagOp, 0, len(newTags)+len(parsed))
+	for _, tag := range newTags {
+		withNew = append(withNew, TagOp{Tag: tag})
+	}
+	withNew = append(withNew, parsed...)
+	if _, err := tagOpts.strictOps(withNew); err != nil {
+		return nil, err
+	}
+
+	dir, err := db.folderPath(folder)
+	if err != nil {
+		return nil, err
+	}
+	var md maildir.Dir
+	if opts.CreateFolder {
+		md, err = maildir.Create(dir)
+	} else {
+		md, err = maildir.Open(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	path, err := md.Deliver(r)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &InsertResult{Path: path}
+	var insertErr error
+	err = db.Atomic(func(db *DB) {
+		msg, err := db.AddMessage(path)
+		if err != nil && err != ErrDuplicateMessageID {
+			os.Remove(path)
+			insertErr = err
+			return
+		}
+		result.Message = msg
+		result.Duplicate = err == ErrDuplicateMessageID
+
+		if tagOpts.SyncMaildirFlags {
+			if err := msg.MaildirFlagsToTags(); err != nil {
+				insertErr = err
+				db.removeFile(msg, path)
+				return
+			}
+		}
+		all := withNew
+		if result.Duplicate {
+			all = parsed
+		}
+		if _, err := msg.ApplyTagOps(all, &tagOpts); err != nil {
+			insertErr = err
+			db.removeFile(msg, path)
+		}
+	})
+	if insertErr != nil {
+		return nil, insertErr
+	}
+	if err != nil {
+		db.removeFile(result.Message, path)
+		return nil, err
+	}
+	// Synchronizing flags may have moved the file.
+	result.Path = currentFilename(result.Message, path)
+	return result, nil
+}
+
+// removeFile removes the file at path, which may have been renamed by
+// flag synchronization, from msg and from the disk.
+func (db *DB) removeFile(msg *Message, path string) {
+	if msg != nil {
+		path = currentFilename(msg, path)
+		db.RemoveMessage(path)
+	}
+	os.Remove(path)
+}
+
+// currentFilename returns the filename of msg which was at path before any
+// flag synchronization: the one with the same unique part.
+func currentFilename(msg *Message, path string) string {
+	base, _ := maildir.SplitInfo(filepath.Base(path))
+	for _, name := range msg.Filenames().slice() {
+		if b, _ := maildir.SplitInfo(filepath.Base(name)); b == base {
+			return name
+		}
+	}
+	return path
+}
+
+// folderPath returns the path of folder, relative to the mail root. It
+// rejects folders escaping the mail root.
+func (db *DB) folderPath(folder string) (string, error) {
+	if filepath.IsAbs(folder) {
+		return "", fmt.Errorf("invalid folder %q: not relative to the mail root", folder)
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(folder), "/") {
+		if elem == ".." {
+			return "", fmt.Errorf("invalid folder %q: not relative to the mail root", folder)
+		}
+	}
+	return filepath.Join(db.mailRoot(), folder), nil
+}
+
+// mailRoot returns the directory holding the mail, database.mail_root if
+// set, or the database path.
+func (db *DB) mailRoot() string {
+	if root, err := db.GetConfig("database.mail_root"); err == nil && root != "" {
+		return root
+	}
+	return db.Path()
+}
+
+// configBool returns the value of a boolean config key, false if unset.
+func (db *DB) configBool(key string) (bool, error) {
+	value, err := db.GetConfig(key)
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "false", "no", "0":
+		return false, nil
+	case "true", "yes", "1":
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid boolean value %q for %s", value, key)
+}
@@ -0,0 +1,100 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testInsertMessage = "From: Alice <alice@example.com>\n" +
+	"To: Bob <bob@example.com>\n" +
+	"Subject: go-notmuch insert test\n" +
+	"Message-ID: <go-notmuch-insert@example.com>\n" +
+	"Date: Mon, 2 Jan 2006 15:04:05 -0700\n" +
+	"\n" +
+	"Hello.\n"
+
+func TestInsert(t *testing.T) {
+	db, err := Open(dbPath, DBReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	folder := "go-notmuch-insert"
+	defer os.RemoveAll(filepath.Join(db.mailRoot(), folder))
+	if _, err := db.Insert(strings.NewReader(testInsertMessage), folder, "", nil); err == nil {
+		t.Errorf("db.Insert(missing folder): want an error got nil")
+	}
+
+	opts := &InsertOptions{CreateFolder: true}
+	res, err := db.Insert(strings.NewReader(testInsertMessage), folder, "+go-notmuch-test -inbox", opts)
+	if err != nil {
+		t.Fatalf("db.Insert(): unexpected error: %s", err)
+	}
+	defer db.RemoveMessage(res.Path)
+	if res.Duplicate {
+		t.Errorf("db.Insert(): want a new message got a duplicate")
+	}
+	if want, got := "go-notmuch-insert@example.com", res.Message.ID(); want != got {
+		t.Errorf("db.Insert(): want message %q got %q", want, got)
+	}
+	if !strings.HasPrefix(res.Path, filepath.Join(db.mailRoot(), folder)+string(filepath.Separator)) {
+		t.Errorf("db.Insert(): want a file in %s got %s", folder, res.Path)
+	}
+	if !containsString(res.Message.Tags().slice(), "go-notmuch-test") || containsString(res.Message.Tags().slice(), "inbox") {
+		t.Errorf("db.Insert(): want tags applied got %v", res.Message.Tags().slice())
+	}
+
+	dup, err := db.Insert(strings.NewReader(testInsertMessage), folder, "+go-notmuch-dup", opts)
+	if err != nil {
+		t.Fatalf("db.Insert(duplicate): unexpected error: %s", err)
+	}
+	defer db.RemoveMessage(dup.Path)
+	if !dup.Duplicate {
+		t.Errorf("db.Insert(duplicate): want a duplicate got a new message")
+	}
+	msg, err := db.FindMessage("go-notmuch-insert@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(msg.Filenames().slice()); want != got {
+		t.Errorf("db.Insert(duplicate): want %d filenames got %d", want, got)
+	}
+
+	for _, bad := range []string{"../outside", "/abs"} {
+		if _, err := db.Insert(strings.NewReader(testInsertMessage), bad, "", opts); err == nil {
+			t.Errorf("db.Insert(%q): want an error got nil", bad)
+		}
+	}
+	strict := &InsertOptions{TagOptions: TagOptions{Strict: &DefaultTagPolicy}}
+	if _, err := db.Insert(strings.NewReader(testInsertMessage), folder, "+a#b", strict); err != ErrTagInvalidChar {
+		t.Errorf("db.Insert(invalid tag): want error %q got %v", ErrTagInvalidChar, err)
+	}
+	files, err := filepath.Glob(filepath.Join(db.mailRoot(), folder, "*", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(files); want != got {
+		t.Errorf("files after a failed insert: want %d got %v", want, files)
+	}
+	if want, got := 2, len(msg.Filenames().slice()); want != got {
+		t.Errorf("filenames after a failed insert: want %d got %d", want, got)
+	}
+
+	// Invalid tags are rejected before the folder is even created.
+	strict.CreateFolder = true
+	other := filepath.Join(db.mailRoot(), "go-notmuch-insert-strict")
+	defer os.RemoveAll(other)
+	if _, err := db.Insert(strings.NewReader(testInsertMessage), filepath.Base(other), "+a#b", strict); err != ErrTagInvalidChar {
+		t.Errorf("db.Insert(invalid tag): want error %q got %v", ErrTagInvalidChar, err)
+	}
+	if _, err := os.Stat(other); !os.IsNotExist(err) {
+		t.Errorf("db.Insert(invalid tag): want no folder created got %v", err)
+	}
+}
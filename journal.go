@@ -0,0 +1,362 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// Journal records the tag changes made through it in an append-only file,
+// so that they can be undone later.
+//
+// The file holds one JSON encoded JournalEntry per line. It is not locked:
+// a journal file must not be shared by several Journal values at once.
+type Journal struct {
+	db   *DB
+	f    *os.File
+	path string
+	seq  int
+}
+
+// JournalEntry is a tag operation recorded in a Journal.
+type JournalEntry struct {
+	// Seq numbers the entries of a journal, starting at 1.
+	Seq  int       `json:"seq"`
+	Time time.Time `json:"time"`
+
+	// Op describes the operation, e.g. "+inbox -unread", "-*" for the
+	// removal of all tags, or "undo".
+	Op    string `json:"op"`
+	Query string `json:"query,omitempty"`
+
+	// Revision and UUID identify the state of the database right after
+	// the operation.
+	Revision uint64 `json:"revision"`
+	UUID     string `json:"uuid"`
+
+	// Messages holds the messages whose tags were changed.
+	Messages []JournalMessage `json:"messages"`
+
+	// Undoes lists the entries reverted by an "undo" entry.
+	Undoes []int `json:"undoes,omitempty"`
+
+	// Partial marks an "undo" entry left by an Undo which failed midway.
+	// It records the messages changed, but the entries in Undoes are not
+	// undone.
+	Partial bool `json:"partial,omitempty"`
+}
+
+// JournalMessage holds the tags of a message before and after a journaled
+// operation, sorted.
+type JournalMessage struct {
+	ID     string   `json:"id"`
+	Before []string `json:"before"`
+	After  []string `json:"after"`
+}
+
+// OpenJournal opens the journal file at path for recording changes made to
+// db, creating it if needed. Caller is responsible for closing the journal
+// when done.
+func OpenJournal(db *DB, path string) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{db: db, f: f, path: path}
+	entries, size, err := j.readEntries()
+	if err == nil {
+		// Drop an incomplete last line, so that new entries start on a
+		// line of their own.
+		var fi os.FileInfo
+		if fi, err = f.Stat(); err == nil && fi.Size() > size {
+			err = f.Truncate(size)
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if len(entries) > 0 {
+		j.seq = entries[len(entries)-1].Seq
+	}
+	return j, nil
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	return j.f.Close()
+}
+
+// Entries returns all the entries of the journal, oldest first. An
+// unterminated last line, left by a crash while it was written, is ignored.
+func (j *Journal) Entries() ([]*JournalEntry, error) {
+	entries, _, err := j.readEntries()
+	return entries, err
+}
+
+// readEntries returns the entries of the journal and the size of the
+// complete lines holding them.
+func (j *Journal) readEntries() ([]*JournalEntry, int64, error) {
+	f, err := os.Open(j.path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var entries []*JournalEntry
+	var size int64
+	r := bufio.NewReader(f)
+	for lineno := 1; ; lineno++ {
+		// Entries of large operations make long lines: do not limit
+		// their length.
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return entries, size, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		size += int64(len(line))
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		entry := &JournalEntry{}
+		if err := json.Unmarshal(line, entry); err != nil {
+			return nil, 0, fmt.Errorf("%s:%d: %s", j.path, lineno, err)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// AddTag adds a tag to the message and records it in the journal.
+func (j *Journal) AddTag(m *Message, tag string) error {
+	return j.record(m, "+"+tag, func() error { return m.AddTag(tag) })
+}
+
+// RemoveTag removes a tag from the message and records it in the journal.
+func (j *Journal) RemoveTag(m *Message, tag string) error {
+	return j.record(m, "-"+tag, func() error { return m.RemoveTag(tag) })
+}
+
+// RemoveAllTags removes all tags from the message and records it in the
+// journal.
+func (j *Journal) RemoveAllTags(m *Message) error {
+	return j.record(m, "-*", m.RemoveAllTags)
+}
+
+// Tag is DB.Tag recording all the changes as a single journal entry.
+func (j *Journal) Tag(query string, ops string, opts *TagOptions) (int, error) {
+	parsed, err := ParseTagOps(ops)
+	if err != nil {
+		return 0, err
+	}
+	var changes []JournalMessage
+	count, err := j.db.applyTagOps(query, parsed, opts, func(m *Message, before []string) {
+		changes = append(changes, JournalMessage{ID: m.ID(), Before: before, After: sortedTags(m)})
+	})
+	// Whatever was changed is recorded, even on error, since notmuch
+	// cannot roll it back.
+	if len(changes) > 0 {
+		if appendErr := j.append(&JournalEntry{Op: ops, Query: query, Messages: changes}); appendErr != nil && err == nil {
+			err = appendErr
+		}
+	}
+	return count, err
+}
+
+// record runs a tag change on a single message and journals it if the tags
+// of the message changed.
+func (j *Journal) record(m *Message, op string, change func() error) error {
+	before := sortedTags(m)
+	err := change()
+	after := sortedTags(m)
+	if equalStrings(before, after) {
+		return err
+	}
+	entry := &JournalEntry{Op: op, Messages: []JournalMessage{{ID: m.ID(), Before: before, After: after}}}
+	if appendErr := j.append(entry); err == nil {
+		err = appendErr
+	}
+	return err
+}
+
+// append completes entry with its sequence number, time and the database
+// revision, and writes it to the journal file.
+func (j *Journal) append(entry *JournalEntry) error {
+	entry.Seq = j.seq + 1
+	entry.Time = time.Now().UTC()
+	entry.Revision, entry.UUID = j.db.Revision()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	j.seq = entry.Seq
+	return j.f.Sync()
+}
+
+// Undo reverts the last n operations of the journal which have not been
+// undone yet, newest first, and records the reversal as an "undo" entry.
+// Undo entries are not undone themselves. It returns the entries reverted.
+//
+// Undo refuses, returning ErrJournalConflict without changing anything, if
+// the tags of any message involved differ from those recorded after the
+// operations, if a message is no longer in the database, or if the
+// operations were recorded in another database, as told by its UUID.
+//
+// If changing the tags fails midway, the messages already reverted are
+// recorded in a partial "undo" entry, and calling Undo again completes the
+// reversal, even if other operations were recorded in between.
+func (j *Journal) Undo(n int) ([]*JournalEntry, error) {
+	entries, err := j.Entries()
+	if err != nil {
+		return nil, err
+	}
+	undone := map[int]bool{}
+	for _, entry := range entries {
+		if entry.Partial {
+			continue
+		}
+		for _, seq := range entry.Undoes {
+			undone[seq] = true
+		}
+	}
+	var todo []*JournalEntry
+	for i := len(entries) - 1; i >= 0 && len(todo) < n; i-- {
+		if entries[i].Op != "undo" && !undone[entries[i].Seq] {
+			todo = append(todo, entries[i])
+		}
+	}
+	if len(todo) == 0 {
+		return nil, nil
+	}
+	// Partial undos of entries none of which was undone since are walked
+	// back too, in order, as if they were undone along with them. Once a
+	// later Undo completed part of one, its changes are part of the
+	// recorded history.
+	inTodo := map[int]bool{}
+	for _, entry := range todo {
+		inTodo[entry.Seq] = true
+	}
+	var walk []*JournalEntry
+	for i := len(entries) - 1; i >= 0 && entries[i].Seq >= todo[len(todo)-1].Seq; i-- {
+		if inTodo[entries[i].Seq] || entries[i].Partial && !anyUndone(entries[i].Undoes, undone) {
+			walk = append(walk, entries[i])
+		}
+	}
+
+	// Revisions are only comparable within a database: refuse to undo
+	// operations recorded in another one, e.g. before a rebuild.
+	_, uuid := j.db.Revision()
+	for _, entry := range walk {
+		if entry.UUID != uuid {
+			return nil, ErrJournalConflict
+		}
+	}
+
+	// Walk back through the entries to check that each one starts from
+	// the state the next newer one left.
+	msgs := map[string]*Message{}
+	current := map[string][]string{}
+	original := map[string][]string{}
+	var order []string
+	for _, entry := range walk {
+		for _, jm := range entry.Messages {
+			if _, ok := msgs[jm.ID]; !ok {
+				msg, err := j.db.FindMessage(jm.ID)
+				if err == ErrNotFound {
+					return nil, ErrJournalConflict
+				}
+				if err != nil {
+					return nil, err
+				}
+				msgs[jm.ID] = msg
+				current[jm.ID] = sortedTags(msg)
+				original[jm.ID] = current[jm.ID]
+				order = append(order, jm.ID)
+			}
+			if !equalStrings(current[jm.ID], jm.After) {
+				return nil, ErrJournalConflict
+			}
+			current[jm.ID] = jm.Before
+		}
+	}
+
+	undo := &JournalEntry{Op: "undo"}
+	for _, entry := range todo {
+		undo.Undoes = append(undo.Undoes, entry.Seq)
+	}
+	var tagErr error
+	err = j.db.Atomic(func(db *DB) {
+		for _, id := range order {
+			_, err := msgs[id].SetTags(current[id], nil)
+			// SetTags may fail after changing some tags.
+			if after := sortedTags(msgs[id]); !equalStrings(original[id], after) {
+				undo.Messages = append(undo.Messages, JournalMessage{ID: id, Before: original[id], After: after})
+			}
+			if err != nil {
+				tagErr = err
+				return
+			}
+		}
+	})
+	if tagErr != nil {
+		// Record what was reverted, so that Undo can be retried.
+		if len(undo.Messages) > 0 {
+			undo.Partial = true
+			if appendErr := j.append(undo); appendErr != nil {
+				return nil, fmt.Errorf("%s; recording the partial undo: %s", tagErr, appendErr)
+			}
+		}
+		return nil, tagErr
+	}
+	// As with Tag, the changes are recorded even if the atomic section
+	// failed.
+	if appendErr := j.append(undo); err == nil {
+		err = appendErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return todo, nil
+}
+
+// anyUndone returns true if any of the entries in seqs is undone.
+func anyUndone(seqs []int, undone map[int]bool) bool {
+	for _, seq := range seqs {
+		if undone[seq] {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedTags returns the tags of the message, sorted.
+func sortedTags(m *Message) []string {
+	tags := m.Tags().slice()
+	sort.Strings(tags)
+	return tags
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
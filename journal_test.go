@@ -0,0 +1,261 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestJournalEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-notmuch-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal")
+
+	// A long line, as left by a large operation, then one cut short by a
+	// crash.
+	big := &JournalEntry{Seq: 1, Op: "-inbox", Query: "*"}
+	for i := 0; i < 5000; i++ {
+		big.Messages = append(big.Messages, JournalMessage{ID: fmt.Sprintf("%d@example.com", i), Before: []string{"inbox"}, After: []string{}})
+	}
+	line, err := json.Marshal(big)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := append(line, '\n')
+	if err := ioutil.WriteFile(path, append(data, `{"seq":2,"op":"+inb`...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := OpenJournal(nil, path)
+	if err != nil {
+		t.Fatalf("OpenJournal(): unexpected error: %s", err)
+	}
+	defer j.Close()
+	entries, err := j.Entries()
+	if err != nil {
+		t.Fatalf("j.Entries(): unexpected error: %s", err)
+	}
+	if len(entries) != 1 || len(entries[0].Messages) != len(big.Messages) {
+		t.Fatalf("j.Entries(): want the first entry only got %d entries", len(entries))
+	}
+	if want, got := 1, j.seq; want != got {
+		t.Errorf("OpenJournal(): want sequence number %d got %d", want, got)
+	}
+	// The incomplete line is dropped, so that the next entry starts on a
+	// line of its own.
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(data) {
+		t.Errorf("OpenJournal(): want the journal truncated to %d bytes got %d", len(data), len(got))
+	}
+}
+
+func TestJournalUndo(t *testing.T) {
+	db, err := Open(dbPath, DBReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	dir, err := ioutil.TempDir("", "go-notmuch-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	j, err := OpenJournal(db, filepath.Join(dir, "journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	msg, err := db.FindMessage("1258471718-6781-2-git-send-email-dottedmag@dottedmag.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.AddTag(msg, "go-notmuch-test"); err != nil {
+		t.Fatalf("j.AddTag(): unexpected error: %s", err)
+	}
+	if err := j.AddTag(msg, "go-notmuch-test"); err != nil {
+		t.Fatalf("j.AddTag() again: unexpected error: %s", err)
+	}
+	if err := j.RemoveTag(msg, "unread"); err != nil {
+		t.Fatalf("j.RemoveTag(): unexpected error: %s", err)
+	}
+
+	entries, err := j.Entries()
+	if err != nil {
+		t.Fatalf("j.Entries(): unexpected error: %s", err)
+	}
+	if want, got := 2, len(entries); want != got {
+		t.Fatalf("j.Entries(): want %d entries got %d", want, got)
+	}
+	want := JournalMessage{ID: msg.ID(), Before: []string{"go-notmuch-test", "inbox", "unread"}, After: []string{"go-notmuch-test", "inbox"}}
+	if got := entries[1].Messages; !reflect.DeepEqual([]JournalMessage{want}, got) {
+		t.Errorf("j.Entries(): want %v got %v", want, got)
+	}
+
+	undone, err := j.Undo(2)
+	if err != nil {
+		t.Fatalf("j.Undo(): unexpected error: %s", err)
+	}
+	if want, got := 2, len(undone); want != got {
+		t.Errorf("j.Undo(): want %d entries undone got %d", want, got)
+	}
+	if want, got := []string{"inbox", "unread"}, msg.Tags().slice(); !reflect.DeepEqual(want, got) {
+		t.Errorf("msg.Tags() after undo: want %v got %v", want, got)
+	}
+	if undone, err := j.Undo(1); err != nil || len(undone) != 0 {
+		t.Errorf("j.Undo() with nothing to undo: want no entries got %d (error %v)", len(undone), err)
+	}
+
+	if err := j.AddTag(msg, "go-notmuch-test"); err != nil {
+		t.Fatalf("j.AddTag(): unexpected error: %s", err)
+	}
+	defer msg.RemoveTag("go-notmuch-test")
+	msg.RemoveTag("unread")
+	defer msg.AddTag("unread")
+	if _, err := j.Undo(1); err != ErrJournalConflict {
+		t.Errorf("j.Undo() after a change: want error %q got %v", ErrJournalConflict, err)
+	}
+	if want, got := []string{"go-notmuch-test", "inbox"}, msg.Tags().slice(); !reflect.DeepEqual(want, got) {
+		t.Errorf("msg.Tags() after refused undo: want %v got %v", want, got)
+	}
+}
+
+func TestJournalUndoPartial(t *testing.T) {
+	db, err := Open(dbPath, DBReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	dir, err := ioutil.TempDir("", "go-notmuch-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	j, err := OpenJournal(db, filepath.Join(dir, "journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	a, err := db.FindMessage("1258471718-6781-2-git-send-email-dottedmag@dottedmag.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := db.FindMessage("20091118002059.067214ed@hikari")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An entry which cannot be undone, as its tags are too long, followed
+	// by one which can.
+	long := make([]byte, TagMax+1)
+	for i := range long {
+		long[i] = 'x'
+	}
+	bad := &JournalEntry{Op: "+bad", Messages: []JournalMessage{{ID: b.ID(), Before: []string{string(long)}, After: sortedTags(b)}}}
+	if err := j.append(bad); err != nil {
+		t.Fatal(err)
+	}
+	before := sortedTags(a)
+	if err := j.AddTag(a, "go-notmuch-test"); err != nil {
+		t.Fatalf("j.AddTag(): unexpected error: %s", err)
+	}
+	defer a.RemoveTag("go-notmuch-test")
+
+	if _, err := j.Undo(2); err != ErrTagTooLong {
+		t.Fatalf("j.Undo(2): want error %q got %v", ErrTagTooLong, err)
+	}
+	if got := sortedTags(a); !reflect.DeepEqual(before, got) {
+		t.Errorf("j.Undo(2): want %s reverted to %v got %v", a.ID(), before, got)
+	}
+	entries, err := j.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := entries[len(entries)-1]
+	if !last.Partial || len(last.Messages) != 1 || last.Messages[0].ID != a.ID() {
+		t.Fatalf("j.Undo(2): want a partial undo entry for %s got %+v", a.ID(), last)
+	}
+
+	// The reversal of the second entry can be completed, even after
+	// another operation.
+	c, err := db.FindMessage("87iqd9rn3l.fsf@vertex.dottedmag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cBefore := sortedTags(c)
+	if err := j.AddTag(c, "go-notmuch-test"); err != nil {
+		t.Fatalf("j.AddTag(): unexpected error: %s", err)
+	}
+	defer c.RemoveTag("go-notmuch-test")
+	undone, err := j.Undo(2)
+	if err != nil {
+		t.Fatalf("j.Undo(2) after a partial undo: unexpected error: %s", err)
+	}
+	if len(undone) != 2 || undone[1].Seq != bad.Seq+1 {
+		t.Errorf("j.Undo(2) after a partial undo: want entries %d and %d undone got %+v", last.Seq+1, bad.Seq+1, undone)
+	}
+	if got := sortedTags(a); !reflect.DeepEqual(before, got) {
+		t.Errorf("j.Undo(2): want %s tags %v got %v", a.ID(), before, got)
+	}
+	if got := sortedTags(c); !reflect.DeepEqual(cBefore, got) {
+		t.Errorf("j.Undo(2): want %s tags %v got %v", c.ID(), cBefore, got)
+	}
+}
+
+func TestJournalUndoOtherDatabase(t *testing.T) {
+	db, err := Open(dbPath, DBReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	dir, err := ioutil.TempDir("", "go-notmuch-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal")
+
+	// An entry recorded before the database was rebuilt.
+	msg, err := db.FindMessage("1258471718-6781-2-git-send-email-dottedmag@dottedmag.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := sortedTags(msg)
+	entry := &JournalEntry{Seq: 1, Op: "+inbox", UUID: "go-notmuch-other", Messages: []JournalMessage{{ID: msg.ID(), Before: []string{}, After: tags}}}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, append(line, '\n'), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := OpenJournal(db, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if _, err := j.Undo(1); err != ErrJournalConflict {
+		t.Errorf("j.Undo() of another database: want error %q got %v", ErrJournalConflict, err)
+	}
+	if got := sortedTags(msg); !reflect.DeepEqual(tags, got) {
+		t.Errorf("j.Undo() of another database: want tags %v kept got %v", tags, got)
+	}
+}
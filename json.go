@@ -0,0 +1,345 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+// This file contains encoders for the JSON formats of `notmuch search` and
+// `notmuch show`, as documented in devel/schemata in the notmuch sources.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// FormatVersion is the version of the notmuch JSON schemata implemented by
+// SearchSummary and the Show* types.
+const FormatVersion = 4
+
+// SearchSummary is the summary of a thread, as printed by
+// `notmuch search --format=json --output=summary`.
+type SearchSummary struct {
+	Thread       string `json:"thread"`
+	Timestamp    int64  `json:"timestamp"`
+	DateRelative string `json:"date_relative"`
+	Matched      int    `json:"matched"`
+	Total        int    `json:"total"`
+	Authors      string `json:"authors"`
+	Subject      string `json:"subject"`
+
+	// Query holds a query for the matched and the unmatched messages of
+	// the thread, in that order. Either is nil if there are no such
+	// messages.
+	Query [2]*string `json:"query"`
+	Tags  []string   `json:"tags"`
+}
+
+// ShowMessage is a message, as printed by `notmuch show --format=json`.
+type ShowMessage struct {
+	ID           string                 `json:"id"`
+	Match        bool                   `json:"match"`
+	Excluded     bool                   `json:"excluded"`
+	Filename     []string               `json:"filename"`
+	Timestamp    int64                  `json:"timestamp"`
+	DateRelative string                 `json:"date_relative"`
+	Tags         []string               `json:"tags"`
+	Crypto       map[string]interface{} `json:"crypto"`
+	Headers      map[string]string      `json:"headers"`
+	Body         []*ShowPart            `json:"body,omitempty"`
+}
+
+// ShowPart is a MIME part of a message, as printed by
+// `notmuch show --format=json`.
+//
+// Content is a string for text parts, a []*ShowPart for multipart parts and
+// a []*ShowEmbeddedMessage for message/rfc822 parts. It is nil for other
+// parts, which only report their length.
+type ShowPart struct {
+	ID                      int         `json:"id"`
+	ContentType             string      `json:"content-type"`
+	ContentDisposition      string      `json:"content-disposition,omitempty"`
+	ContentID               string      `json:"content-id,omitempty"`
+	Filename                string      `json:"filename,omitempty"`
+	ContentCharset          string      `json:"content-charset,omitempty"`
+	ContentLength           int         `json:"content-length,omitempty"`
+	ContentTransferEncoding string      `json:"content-transfer-encoding,omitempty"`
+	Content                 interface{} `json:"content,omitempty"`
+}
+
+// ShowEmbeddedMessage is the content of a message/rfc822 part.
+type ShowEmbeddedMessage struct {
+	Headers map[string]string `json:"headers"`
+	Body    []*ShowPart       `json:"body"`
+}
+
+// ShowNode is a message together with its replies. It is encoded as the
+// two-element array [message, [replies...]] used by notmuch.
+type ShowNode struct {
+	Message *ShowMessage
+	Replies []*ShowNode
+}
+
+// MarshalJSON implements json.Marshaler.
+func (n *ShowNode) MarshalJSON() ([]byte, error) {
+	replies := n.Replies
+	if replies == nil {
+		replies = []*ShowNode{}
+	}
+	return json.Marshal([]interface{}{n.Message, replies})
+}
+
+// ShowOptions controls the output of Message.Show and Thread.Show.
+type ShowOptions struct {
+	// IncludeHTML includes the content of text/html parts, like
+	// `notmuch show --include-html`.
+	IncludeHTML bool
+
+	// OmitBody leaves out the body of messages, like
+	// `notmuch show --body=false`.
+	OmitBody bool
+}
+
+// SearchSummary returns the summary of the thread. sort decides whether the
+// timestamp is that of the oldest or the newest message, as it does for
+// `notmuch search`.
+func (t *Thread) SearchSummary(sort SortMode) *SearchSummary {
+	date := t.NewestDate()
+	if sort == SORT_OLDEST_FIRST {
+		date = t.OldestDate()
+	}
+	summary := &SearchSummary{
+		Thread:       t.ID(),
+		Timestamp:    date.Unix(),
+		DateRelative: relativeDate(date, time.Now()),
+		Matched:      t.CountMatched(),
+		Total:        t.Count(),
+		Authors:      t.authors(),
+		Subject:      t.Subject(),
+		Tags:         t.Tags().slice(),
+	}
+
+	var matched, unmatched []string
+	msgs := t.Messages()
+	var msg *Message
+	for msgs.Next(&msg) {
+		term := booleanTerm("id", msg.ID())
+		if msg.Flag(MessageFlagMatch) {
+			matched = append(matched, term)
+		} else {
+			unmatched = append(unmatched, term)
+		}
+	}
+	if len(matched) > 0 {
+		q := strings.Join(matched, " or ")
+		summary.Query[0] = &q
+	}
+	if len(unmatched) > 0 {
+		q := strings.Join(unmatched, " or ")
+		summary.Query[1] = &q
+	}
+	return summary
+}
+
+// Show returns the message in the form printed by `notmuch show`. Unless
+// opts.OmitBody is set, the message file is read to produce the body.
+// opts may be nil.
+func (m *Message) Show(opts *ShowOptions) (*ShowMessage, error) {
+	if opts == nil {
+		opts = &ShowOptions{}
+	}
+	date := m.Date()
+	sm := &ShowMessage{
+		ID:           m.ID(),
+		Match:        m.Flag(MessageFlagMatch),
+		Excluded:     m.Flag(MessageFlagExcluded),
+		Filename:     m.Filenames().slice(),
+		Timestamp:    date.Unix(),
+		DateRelative: relativeDate(date, time.Now()),
+		Tags:         m.Tags().slice(),
+		Crypto:       map[string]interface{}{},
+	}
+
+	parsed, err := readMIMEFile(m.Filename())
+	if err != nil {
+		return nil, err
+	}
+	sm.Headers = showHeaders(parsed.header)
+	if !opts.OmitBody {
+		var id int
+		sm.Body = []*ShowPart{showPart(parsed.root, &id, opts)}
+	}
+	return sm, nil
+}
+
+// Show returns the messages of the thread in the form printed by
+// `notmuch show`: a list of the top-level messages, each with its replies.
+// opts may be nil.
+func (t *Thread) Show(opts *ShowOptions) ([]*ShowNode, error) {
+	return showNodes(t.TopLevelMessages(), opts)
+}
+
+func showNodes(msgs *Messages, opts *ShowOptions) ([]*ShowNode, error) {
+	nodes := []*ShowNode{}
+	var msg *Message
+	for msgs.Next(&msg) {
+		sm, err := msg.Show(opts)
+		if err != nil {
+			return nil, err
+		}
+		node := &ShowNode{Message: sm, Replies: []*ShowNode{}}
+		if replies, err := msg.Replies(); err == nil {
+			if node.Replies, err = showNodes(replies, opts); err != nil {
+				return nil, err
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// WriteSearchJSON writes the summaries of threads to w in the format of
+// `notmuch search --format=json`.
+func WriteSearchJSON(w io.Writer, threads *Threads, sort SortMode) error {
+	return writeJSONArray(w, func(emit func(interface{}) error) error {
+		var thread *Thread
+		for threads.Next(&thread) {
+			if err := emit(thread.SearchSummary(sort)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// WriteShowJSON writes threads to w in the format of
+// `notmuch show --format=json`. opts may be nil.
+func WriteShowJSON(w io.Writer, threads *Threads, opts *ShowOptions) error {
+	return writeJSONArray(w, func(emit func(interface{}) error) error {
+		var thread *Thread
+		for threads.Next(&thread) {
+			nodes, err := thread.Show(opts)
+			if err != nil {
+				return err
+			}
+			if err := emit(nodes); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeJSONArray writes a JSON array to w, encoding each value passed to
+// emit as it arrives rather than building the whole array in memory.
+func writeJSONArray(w io.Writer, body func(emit func(interface{}) error) error) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	sep := ""
+	err := body(func(v interface{}) error {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
+		}
+		sep = ",\n"
+		_, err = w.Write(b)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "]\n")
+	return err
+}
+
+// showHeaders returns the headers notmuch includes in its JSON output.
+// Subject, From and Date are always present; the others only if the
+// message has them.
+func showHeaders(header textproto.MIMEHeader) map[string]string {
+	headers := map[string]string{
+		"Subject": decodeHeader(header.Get("Subject")),
+		"From":    decodeHeader(header.Get("From")),
+		"Date":    header.Get("Date"),
+	}
+	for _, name := range []string{"To", "Cc", "Bcc", "Reply-To"} {
+		if value := header.Get(name); value != "" {
+			headers[name] = decodeHeader(value)
+		}
+	}
+	return headers
+}
+
+// showPart converts p to a ShowPart. Parts are numbered depth-first,
+// starting at 1; id holds the last number handed out.
+func showPart(p *mimePart, id *int, opts *ShowOptions) *ShowPart {
+	*id++
+	sp := &ShowPart{
+		ID:                 *id,
+		ContentType:        p.mediaType,
+		ContentDisposition: p.disposition(),
+		ContentID:          strings.Trim(p.header.Get("Content-ID"), "<> \t"),
+		Filename:           p.filename(),
+	}
+	switch {
+	case p.isMultipart():
+		parts := []*ShowPart{}
+		for _, child := range p.parts {
+			parts = append(parts, showPart(child, id, opts))
+		}
+		sp.Content = parts
+	case p.message != nil:
+		sp.Content = []*ShowEmbeddedMessage{{
+			Headers: showHeaders(p.message.header),
+			Body:    []*ShowPart{showPart(p.message.root, id, opts)},
+		}}
+	case p.isText() && (p.mediaType != "text/html" || opts.IncludeHTML):
+		sp.Content = p.text()
+	case p.isText():
+		// Like notmuch, leave charset decoding of omitted HTML parts to
+		// whoever fetches them.
+		sp.ContentCharset = p.params["charset"]
+		sp.ContentLength = len(p.body)
+	default:
+		sp.ContentTransferEncoding = strings.ToLower(p.header.Get("Content-Transfer-Encoding"))
+		sp.ContentLength = len(p.body)
+	}
+	return sp
+}
+
+// relativeDate formats then relative to now the way notmuch's
+// notmuch_time_relative_date does, e.g. "5 mins. ago", "Today 12:30",
+// "Yest. 12:30", "Mon. 12:30", "October 12" or "2008-06-30".
+func relativeDate(then, now time.Time) string {
+	const day = 24 * time.Hour
+
+	then = then.Local()
+	now = now.Local()
+	if then.After(now) {
+		return "the future"
+	}
+	delta := now.Sub(then)
+	if delta > 180*day {
+		return then.Format("2006-01-02")
+	}
+	if delta < time.Hour {
+		return fmt.Sprintf("%d mins. ago", int(delta/time.Minute))
+	}
+	if delta <= 7*day {
+		switch {
+		case then.Weekday() == now.Weekday() && delta < day:
+			return then.Format("Today 15:04")
+		case (int(now.Weekday())+7-int(then.Weekday()))%7 == 1:
+			return then.Format("Yest. 15:04")
+		case then.Weekday() != now.Weekday():
+			return then.Format("Mon. 15:04")
+		}
+	}
+	return then.Format("January 02")
+}
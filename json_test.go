@@ -0,0 +1,191 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testMultipartMessage = "From: Alice <alice@example.com>\r\n" +
+	"To: Bob <bob@example.com>\r\n" +
+	"Subject: =?utf-8?q?caf=C3=A9?=\r\n" +
+	"Date: Tue, 17 Nov 2009 18:21:38 -0500\r\n" +
+	"Message-ID: <multipart@example.com>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"XYZ\"\r\n" +
+	"\r\n" +
+	"--XYZ\r\n" +
+	"Content-Type: text/plain; charset=iso-8859-1\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"caf=E9\r\n" +
+	"--XYZ\r\n" +
+	"Content-Type: text/html\r\n" +
+	"\r\n" +
+	"<p>hi</p>\r\n" +
+	"--XYZ\r\n" +
+	"Content-Type: application/octet-stream\r\n" +
+	"Content-Disposition: attachment; filename=\"data.bin\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"AAECAw==\r\n" +
+	"--XYZ--\r\n"
+
+func TestShowPart(t *testing.T) {
+	msg, err := readMIMEMessage(strings.NewReader(testMultipartMessage), 0)
+	if err != nil {
+		t.Fatalf("readMIMEMessage(): unexpected error: %s", err)
+	}
+	if want, got := "café", showHeaders(msg.header)["Subject"]; want != got {
+		t.Errorf("showHeaders()[Subject]: want %q got %q", want, got)
+	}
+
+	var id int
+	root := showPart(msg.root, &id, &ShowOptions{})
+	if want, got := "multipart/mixed", root.ContentType; want != got {
+		t.Errorf("root.ContentType: want %s got %s", want, got)
+	}
+	parts, ok := root.Content.([]*ShowPart)
+	if !ok || len(parts) != 3 {
+		t.Fatalf("root.Content: want 3 parts got %#v", root.Content)
+	}
+	if want, got := "café", parts[0].Content; want != got {
+		t.Errorf("text part content: want %q got %q", want, got)
+	}
+	if parts[1].Content != nil || parts[1].ContentLength != len("<p>hi</p>") {
+		t.Errorf("html part: want omitted content got %#v", parts[1])
+	}
+	if want, got := (ShowPart{
+		ID:                      4,
+		ContentType:             "application/octet-stream",
+		ContentDisposition:      "attachment",
+		Filename:                "data.bin",
+		ContentLength:           4,
+		ContentTransferEncoding: "base64",
+	}), *parts[2]; want != got {
+		t.Errorf("attachment part: want %#v got %#v", want, got)
+	}
+
+	id = 0
+	root = showPart(msg.root, &id, &ShowOptions{IncludeHTML: true})
+	if want, got := "<p>hi</p>", root.Content.([]*ShowPart)[1].Content; want != got {
+		t.Errorf("html part with IncludeHTML: want %q got %q", want, got)
+	}
+}
+
+func TestValidUTF8(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"café", "café"},
+		{"caf\xe9", "caf\ufffd"},
+		{"\xff\xfeok", "\ufffd\ufffdok"},
+	}
+	for _, tt := range tests {
+		if got := validUTF8([]byte(tt.in)); tt.want != got {
+			t.Errorf("validUTF8(%q): want %q got %q", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestShowNodeMarshalJSON(t *testing.T) {
+	node := &ShowNode{Message: &ShowMessage{ID: "a"}}
+	b, err := json.Marshal([]*ShowNode{node})
+	if err != nil {
+		t.Fatalf("json.Marshal(): unexpected error: %s", err)
+	}
+	var decoded [][]json.RawMessage
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal(%s): unexpected error: %s", b, err)
+	}
+	if len(decoded) != 1 || len(decoded[0]) != 2 || string(decoded[0][1]) != "[]" {
+		t.Errorf("json.Marshal(node): want [[message, []]] got %s", b)
+	}
+}
+
+func TestRelativeDate(t *testing.T) {
+	now := time.Date(2009, time.November, 18, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		then time.Time
+		want string
+	}{
+		{now.Add(time.Minute), "the future"},
+		{now.Add(-5 * time.Minute), "5 mins. ago"},
+		{now.Add(-3 * time.Hour), "Today 09:00"},
+		{now.Add(-15 * time.Hour), "Yest. 21:00"},
+		{now.Add(-3 * 24 * time.Hour), "Sun. 12:00"},
+		{now.Add(-7 * 24 * time.Hour), "November 11"},
+		{now.Add(-30 * 24 * time.Hour), "October 19"},
+		{now.Add(-365 * 24 * time.Hour), "2008-11-18"},
+	}
+	for _, tt := range tests {
+		if got := relativeDate(tt.then, now); got != tt.want {
+			t.Errorf("relativeDate(%s, %s): want %q got %q", tt.then, now, tt.want, got)
+		}
+	}
+}
+
+func TestThreadSearchSummary(t *testing.T) {
+	db, err := Open(dbPath, DBReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	thread, err := firstThread(db, "subject:\"Introducing myself\"")
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := thread.SearchSummary(SORT_NEWEST_FIRST)
+	if want, got := thread.ID(), summary.Thread; want != got {
+		t.Errorf("summary.Thread: want %s got %s", want, got)
+	}
+	if want, got := thread.NewestDate().Unix(), summary.Timestamp; want != got {
+		t.Errorf("summary.Timestamp: want %d got %d", want, got)
+	}
+	if summary.Query[0] == nil || !strings.HasPrefix(*summary.Query[0], "id:") {
+		t.Errorf("summary.Query[0]: want an id: query got %v", summary.Query[0])
+	}
+	if summary.Query[1] != nil {
+		t.Errorf("summary.Query[1]: want nil got %q", *summary.Query[1])
+	}
+}
+
+func TestMessageShow(t *testing.T) {
+	db, err := Open(dbPath, DBReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	thread, err := firstThread(db, "subject:\"Introducing myself\"")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := thread.Show(nil)
+	if err != nil {
+		t.Fatalf("thread.Show(): unexpected error: %s", err)
+	}
+	if want, got := 1, len(nodes); want != got {
+		t.Fatalf("thread.Show(): want %d top-level messages got %d", want, got)
+	}
+	msg := nodes[0].Message
+	if want, got := "20091118002059.067214ed@hikari", msg.ID; want != got {
+		t.Errorf("msg.ID: want %s got %s", want, got)
+	}
+	if want, got := "Introducing myself", msg.Headers["Subject"]; !strings.Contains(got, want) {
+		t.Errorf("msg.Headers[Subject]: want %q got %q", want, got)
+	}
+	if len(msg.Body) != 1 {
+		t.Errorf("msg.Body: want a single root part got %d", len(msg.Body))
+	}
+	if want, got := 2, len(nodes[0].Replies); want != got {
+		t.Errorf("nodes[0].Replies: want %d got %d", want, got)
+	}
+}
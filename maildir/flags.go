@@ -0,0 +1,76 @@
+package maildir
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"sort"
+	"strings"
+)
+
+// Separator separates the unique part of a message file name from its info.
+const Separator = ':'
+
+// infoPrefix starts the "2," info holding the flags of a message.
+const infoPrefix = ":2,"
+
+// The flags notmuch synchronizes with tags.
+const (
+	FlagDraft   = 'D'
+	FlagFlagged = 'F'
+	FlagPassed  = 'P'
+	FlagReplied = 'R'
+	FlagSeen    = 'S'
+)
+
+// FlagTrashed marks a message as trashed. notmuch does not synchronize it
+// with any tag.
+const FlagTrashed = 'T'
+
+// SplitInfo splits a message file name into its unique part and its info,
+// without the separator, e.g. "2,FS". The info is empty if there is none.
+func SplitInfo(name string) (base, info string) {
+	if i := strings.IndexByte(name, Separator); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return name, ""
+}
+
+// ParseFlags returns the flags of the message with the given file name,
+// sorted. Like notmuch, it only considers info of the form "2,FLAGS".
+func ParseFlags(name string) string {
+	_, info := SplitInfo(name)
+	if !strings.HasPrefix(info, "2,") {
+		return ""
+	}
+	return NormalizeFlags(info[len("2,"):])
+}
+
+// HasFlag returns true if the message with the given file name has flag.
+func HasFlag(name string, flag byte) bool {
+	return strings.IndexByte(ParseFlags(name), flag) >= 0
+}
+
+// SetFlags returns the file name with its info replaced by ":2," and flags.
+// Info other than "2," is replaced too.
+func SetFlags(name, flags string) string {
+	base, _ := SplitInfo(name)
+	return base + infoPrefix + NormalizeFlags(flags)
+}
+
+// NormalizeFlags sorts flags in ASCII order and removes duplicates and
+// anything but ASCII letters, as the maildir specification requires.
+func NormalizeFlags(flags string) string {
+	seen := map[byte]bool{}
+	var b []byte
+	for i := 0; i < len(flags); i++ {
+		c := flags[i]
+		if ('A' <= c && c <= 'Z' || 'a' <= c && c <= 'z') && !seen[c] {
+			seen[c] = true
+			b = append(b, c)
+		}
+	}
+	sort.Slice(b, func(i, j int) bool { return b[i] < b[j] })
+	return string(b)
+}
@@ -0,0 +1,44 @@
+package maildir
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import "testing"
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name, flags string
+	}{
+		{"1234.M1P2.host", ""},
+		{"1234.M1P2.host:2,", ""},
+		{"1234.M1P2.host:2,SF", "FS"},
+		{"1234.M1P2.host:2,RSS", "RS"},
+		{"1234.M1P2.host:1,S", ""},
+	}
+	for _, tt := range tests {
+		if got := ParseFlags(tt.name); tt.flags != got {
+			t.Errorf("ParseFlags(%q): want %q got %q", tt.name, tt.flags, got)
+		}
+	}
+	if !HasFlag("x:2,FS", FlagSeen) || HasFlag("x:2,F", FlagSeen) {
+		t.Errorf("HasFlag(): wrong result for the seen flag")
+	}
+}
+
+func TestSetFlags(t *testing.T) {
+	tests := []struct {
+		name, flags, want string
+	}{
+		{"x", "S", "x:2,S"},
+		{"x:2,S", "", "x:2,"},
+		{"x:2,S", "TRS", "x:2,RST"},
+		{"x:1,foo", "F", "x:2,F"},
+		{"x", "S F,9", "x:2,FS"},
+	}
+	for _, tt := range tests {
+		if got := SetFlags(tt.name, tt.flags); tt.want != got {
+			t.Errorf("SetFlags(%q, %q): want %q got %q", tt.name, tt.flags, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Package maildir creates maildirs and delivers messages to them, in a way
+// compatible with notmuch's handling of maildir flags.
+package maildir
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// The subdirectories of a maildir.
+const (
+	Tmp = "tmp"
+	New = "new"
+	Cur = "cur"
+)
+
+// ErrNotMaildir is returned by Open when a directory lacks one of the tmp,
+// new and cur subdirectories.
+var ErrNotMaildir = errors.New("not a maildir")
+
+// Dir is the path of a maildir, a directory holding tmp, new and cur
+// subdirectories.
+type Dir string
+
+// Create creates the maildir at path, along with any missing parent
+// directory. It succeeds if the maildir already exists. The returned Dir
+// holds an absolute path, so the paths of delivered messages are absolute
+// too, as DB.AddMessage wants.
+func Create(path string) (Dir, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	for _, sub := range []string{Tmp, New, Cur} {
+		if err := os.MkdirAll(filepath.Join(abs, sub), 0700); err != nil {
+			return "", err
+		}
+	}
+	return Dir(abs), nil
+}
+
+// Open returns the maildir at path, or ErrNotMaildir if path is not one.
+func Open(path string) (Dir, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	for _, sub := range []string{Tmp, New, Cur} {
+		fi, err := os.Stat(filepath.Join(abs, sub))
+		if os.IsNotExist(err) || err == nil && !fi.IsDir() {
+			return "", ErrNotMaildir
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return Dir(abs), nil
+}
+
+// Path returns the path of the maildir.
+func (d Dir) Path() string {
+	return string(d)
+}
+
+// Deliver writes the message read from r to the new subdirectory of the
+// maildir and returns its path. The message is written to tmp first and
+// only moved to new once safely on disk, so readers never see it partially
+// written.
+func (d Dir) Deliver(r io.Reader) (string, error) {
+	return d.deliver(r, New, "")
+}
+
+// DeliverFlags is like Deliver, but delivers the message to the cur
+// subdirectory with the given flags, as for a message which has already
+// been seen by a mail reader.
+func (d Dir) DeliverFlags(r io.Reader, flags string) (string, error) {
+	return d.deliver(r, Cur, infoPrefix+NormalizeFlags(flags))
+}
+
+func (d Dir) deliver(r io.Reader, sub, info string) (string, error) {
+	name, err := UniqueName()
+	if err != nil {
+		return "", err
+	}
+	tmp := filepath.Join(string(d), Tmp, name)
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	dest := filepath.Join(string(d), sub, name+info)
+	if err := MoveNoReplace(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return dest, nil
+}
+
+// MoveNoReplace moves the file at src to dest, failing if dest exists. It
+// links and unlinks, as is traditional for maildirs, falling back to a
+// rename on file systems without hard links and to a copy across file
+// systems. Once dest is written the move has succeeded: failing to unlink
+// src only leaves a stale copy behind.
+func MoveNoReplace(src, dest string) error {
+	err := os.Link(src, dest)
+	if err == nil {
+		os.Remove(src)
+		return nil
+	}
+	if os.IsExist(err) {
+		return err
+	}
+	if _, statErr := os.Lstat(dest); statErr == nil {
+		return &os.LinkError{Op: "rename", Old: src, New: dest, Err: os.ErrExist}
+	}
+	err = os.Rename(src, dest)
+	if linkErr, ok := err.(*os.LinkError); ok && linkErr.Err == syscall.EXDEV {
+		return copyNoReplace(src, dest)
+	}
+	return err
+}
+
+// copyNoReplace copies the file at src to dest, failing if dest exists, and
+// removes src.
+func copyNoReplace(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dest)
+		return err
+	}
+	os.Remove(src)
+	return nil
+}
+
+// Messages returns the paths of the messages in the new and cur
+// subdirectories of the maildir, sorted. Files whose name starts with a dot
+// are skipped.
+func (d Dir) Messages() ([]string, error) {
+	var paths []string
+	for _, sub := range []string{New, Cur} {
+		dir := filepath.Join(string(d), sub)
+		f, err := os.Open(dir)
+		if err != nil {
+			return nil, err
+		}
+		names, err := f.Readdirnames(-1)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if !strings.HasPrefix(name, ".") {
+				paths = append(paths, filepath.Join(dir, name))
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// MoveToCur moves the message at path, in the new or cur subdirectory of
+// the maildir, to cur with the given flags, and returns its new path.
+func (d Dir) MoveToCur(path, flags string) (string, error) {
+	dest := filepath.Join(string(d), Cur, SetFlags(filepath.Base(path), flags))
+	return d.move(path, dest)
+}
+
+// MoveToNew moves the message at path, in the new or cur subdirectory of
+// the maildir, to new, dropping its flags, and returns its new path.
+func (d Dir) MoveToNew(path string) (string, error) {
+	base, _ := SplitInfo(filepath.Base(path))
+	return d.move(path, filepath.Join(string(d), New, base))
+}
+
+func (d Dir) move(path, dest string) (string, error) {
+	if sub := filepath.Base(filepath.Dir(path)); sub != New && sub != Cur ||
+		filepath.Dir(filepath.Dir(path)) != filepath.Clean(string(d)) {
+		return "", fmt.Errorf("%s is not a message of maildir %s", path, d)
+	}
+	if path == dest {
+		return path, nil
+	}
+	if err := MoveNoReplace(path, dest); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+var deliveries uint64
+
+// UniqueName returns a file name for a new message, unique among those
+// delivered by any process on any host, of the form
+// "<seconds>.M<microseconds>P<pid>Q<count>R<random>.<host>".
+func UniqueName() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	host = strings.Replace(host, "/", `\057`, -1)
+	host = strings.Replace(host, ":", `\072`, -1)
+	var random [8]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	return fmt.Sprintf("%d.M%dP%dQ%dR%s.%s",
+		now.Unix(), now.Nanosecond()/1000, os.Getpid(),
+		atomic.AddUint64(&deliveries, 1), hex.EncodeToString(random[:]), host), nil
+}
@@ -0,0 +1,172 @@
+package maildir
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func tempMaildir(t *testing.T) (Dir, func()) {
+	tmp, err := ioutil.TempDir("", "go-notmuch-maildir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := Create(filepath.Join(tmp, "inbox"))
+	if err != nil {
+		os.RemoveAll(tmp)
+		t.Fatalf("Create(): unexpected error: %s", err)
+	}
+	return d, func() { os.RemoveAll(tmp) }
+}
+
+func TestCreateOpen(t *testing.T) {
+	d, cleanup := tempMaildir(t)
+	defer cleanup()
+
+	if _, err := Create(d.Path()); err != nil {
+		t.Errorf("Create() again: unexpected error: %s", err)
+	}
+	if got, err := Open(d.Path()); err != nil || got != d {
+		t.Errorf("Open(): want %q got %q (error %v)", d, got, err)
+	}
+	if _, err := Open(filepath.Join(d.Path(), Cur)); err != ErrNotMaildir {
+		t.Errorf("Open(cur): want error %q got %v", ErrNotMaildir, err)
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	d, cleanup := tempMaildir(t)
+	defer cleanup()
+
+	content := "Subject: hello\n\nworld\n"
+	path, err := d.Deliver(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("d.Deliver(): unexpected error: %s", err)
+	}
+	if want, got := filepath.Join(d.Path(), New), filepath.Dir(path); want != got {
+		t.Errorf("d.Deliver(): want a file in %s got %s", want, path)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != content {
+		t.Errorf("d.Deliver(): want content %q got %q (error %v)", content, data, err)
+	}
+	if names, _ := ioutil.ReadDir(filepath.Join(d.Path(), Tmp)); len(names) != 0 {
+		t.Errorf("d.Deliver(): want tmp empty got %d files", len(names))
+	}
+
+	seen, err := d.DeliverFlags(strings.NewReader(content), "SF")
+	if err != nil {
+		t.Fatalf("d.DeliverFlags(): unexpected error: %s", err)
+	}
+	if want, got := "FS", ParseFlags(filepath.Base(seen)); want != got {
+		t.Errorf("d.DeliverFlags(): want flags %q got %q", want, got)
+	}
+	if want, got := filepath.Join(d.Path(), Cur), filepath.Dir(seen); want != got {
+		t.Errorf("d.DeliverFlags(): want a file in %s got %s", want, seen)
+	}
+
+	msgs, err := d.Messages()
+	if err != nil {
+		t.Fatalf("d.Messages(): unexpected error: %s", err)
+	}
+	if want := []string{seen, path}; !reflect.DeepEqual(want, msgs) {
+		t.Errorf("d.Messages(): want %v got %v", want, msgs)
+	}
+}
+
+func TestMove(t *testing.T) {
+	d, cleanup := tempMaildir(t)
+	defer cleanup()
+
+	path, err := d.Deliver(strings.NewReader("Subject: hello\n\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cur, err := d.MoveToCur(path, "S")
+	if err != nil {
+		t.Fatalf("d.MoveToCur(): unexpected error: %s", err)
+	}
+	if want, got := filepath.Join(d.Path(), Cur, filepath.Base(path)+":2,S"), cur; want != got {
+		t.Errorf("d.MoveToCur(): want %s got %s", want, got)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("d.MoveToCur(): want %s removed got error %v", path, err)
+	}
+
+	back, err := d.MoveToNew(cur)
+	if err != nil {
+		t.Fatalf("d.MoveToNew(): unexpected error: %s", err)
+	}
+	if want, got := path, back; want != got {
+		t.Errorf("d.MoveToNew(): want %s got %s", want, got)
+	}
+
+	if _, err := d.MoveToCur(filepath.Join(d.Path(), Tmp, "x"), ""); err == nil {
+		t.Errorf("d.MoveToCur(tmp file): want an error got nil")
+	}
+}
+
+func TestMoveNoReplace(t *testing.T) {
+	d, cleanup := tempMaildir(t)
+	defer cleanup()
+
+	src := filepath.Join(d.Path(), Tmp, "a")
+	dest := filepath.Join(d.Path(), New, "a")
+	if err := ioutil.WriteFile(src, []byte("Subject: hello\n\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dest, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := MoveNoReplace(src, dest); !os.IsExist(err) {
+		t.Errorf("MoveNoReplace(existing dest): want an exist error got %v", err)
+	}
+	os.Remove(dest)
+	if err := MoveNoReplace(src, dest); err != nil {
+		t.Fatalf("MoveNoReplace(): unexpected error: %s", err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("MoveNoReplace(): want %s removed got error %v", src, err)
+	}
+
+	// Moves across file systems copy the file.
+	copied := filepath.Join(d.Path(), Cur, "a")
+	if err := copyNoReplace(dest, copied); err != nil {
+		t.Fatalf("copyNoReplace(): unexpected error: %s", err)
+	}
+	if data, err := ioutil.ReadFile(copied); err != nil || string(data) != "Subject: hello\n\n" {
+		t.Errorf("copyNoReplace(): want the content copied got %q (error %v)", data, err)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("copyNoReplace(): want %s removed got error %v", dest, err)
+	}
+	if err := ioutil.WriteFile(dest, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyNoReplace(dest, copied); !os.IsExist(err) {
+		t.Errorf("copyNoReplace(existing dest): want an exist error got %v", err)
+	}
+}
+
+func TestUniqueName(t *testing.T) {
+	a, err := UniqueName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := UniqueName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Errorf("UniqueName(): want distinct names got %q twice", a)
+	}
+	if strings.ContainsAny(a, ":/") {
+		t.Errorf("UniqueName(): want no ':' or '/' got %q", a)
+	}
+}
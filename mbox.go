@@ -0,0 +1,181 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+)
+
+// mboxDateFormat is the asctime(3) format used in From_ lines.
+const mboxDateFormat = "Mon Jan _2 15:04:05 2006"
+
+// MboxWriter writes messages to an io.Writer in mboxrd format: each message
+// is preceded by a From_ line, and lines of the message matching /^>*From /
+// get an additional '>' so that they can be told apart from From_ lines.
+//
+// Message files are copied a line at a time, so a MboxWriter never holds
+// more than a single line of a message in memory, and WriteMessages,
+// WriteThread and WriteQuery walk their results as they write them.
+type MboxWriter struct {
+	w *bufio.Writer
+
+	// TagHeader is the name of a header, such as "X-Keywords", which is
+	// added to each message listing its tags. Any header of that name
+	// already present in the message is dropped. If empty, no header is
+	// added.
+	TagHeader string
+
+	// Stream flushes the output after every message, so that whoever
+	// reads the other end sees each message as soon as it is complete.
+	Stream bool
+}
+
+// NewMboxWriter returns a MboxWriter writing to w. Callers must call Flush
+// once they are done writing.
+func NewMboxWriter(w io.Writer) *MboxWriter {
+	return &MboxWriter{w: bufio.NewWriter(w)}
+}
+
+// Flush writes any buffered data to the underlying io.Writer.
+func (mw *MboxWriter) Flush() error {
+	return mw.w.Flush()
+}
+
+// WriteMessage writes the message to the mbox, reading it from
+// Message.Filename.
+func (mw *MboxWriter) WriteMessage(m *Message) error {
+	f, err := os.Open(m.Filename())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var tags []string
+	if mw.TagHeader != "" {
+		tags = m.Tags().slice()
+	}
+	return mw.write(f, mboxSender(m.Header("Return-Path"), m.Header("From")), m.Date(), tags)
+}
+
+// WriteMessages writes every message left in msgs to the mbox.
+func (mw *MboxWriter) WriteMessages(msgs *Messages) error {
+	var msg *Message
+	for msgs.Next(&msg) {
+		if err := mw.WriteMessage(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteThread writes the messages of the thread to the mbox, oldest first.
+func (mw *MboxWriter) WriteThread(t *Thread) error {
+	return mw.WriteMessages(t.Messages())
+}
+
+// WriteQuery writes the messages matching the query to the mbox, in the
+// query's sort order.
+func (mw *MboxWriter) WriteQuery(q *Query) error {
+	msgs, err := q.Messages()
+	if err != nil {
+		return err
+	}
+	return mw.WriteMessages(msgs)
+}
+
+// write copies the message in r to the mbox, escaping From_ lines and
+// converting CRLF line endings to LF.
+func (mw *MboxWriter) write(r io.Reader, sender string, date time.Time, tags []string) error {
+	w := mw.w
+	w.WriteString("From " + sender + " " + date.UTC().Format(mboxDateFormat) + "\n")
+	if mw.TagHeader != "" {
+		w.WriteString(mw.TagHeader + ": " + strings.Join(tags, ", ") + "\n")
+	}
+
+	br := bufio.NewReader(r)
+	atLineStart := true
+	inHeader := true
+	skipping := false
+	for {
+		line, err := br.ReadSlice('\n')
+		if len(line) > 0 {
+			if atLineStart && inHeader {
+				blank := len(bytes.TrimRight(line, "\r\n")) == 0
+				continuation := line[0] == ' ' || line[0] == '\t'
+				switch {
+				case blank:
+					inHeader = false
+					skipping = false
+				case !continuation:
+					skipping = mw.TagHeader != "" && isHeader(line, mw.TagHeader)
+				}
+			}
+			if atLineStart && isFromLine(line) {
+				w.WriteByte('>')
+			}
+			complete := line[len(line)-1] == '\n'
+			if complete {
+				line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+			}
+			if !skipping {
+				w.Write(line)
+				if complete {
+					w.WriteByte('\n')
+				}
+			}
+			atLineStart = complete
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !atLineStart {
+		w.WriteByte('\n')
+	}
+	// The blank line separating messages.
+	if err := w.WriteByte('\n'); err != nil {
+		return err
+	}
+	if mw.Stream {
+		return w.Flush()
+	}
+	return nil
+}
+
+// isFromLine reports whether line matches /^>*From /, i.e. whether it needs
+// escaping in mboxrd.
+func isFromLine(line []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From "))
+}
+
+// isHeader reports whether line starts the header name.
+func isHeader(line []byte, name string) bool {
+	i := bytes.IndexByte(line, ':')
+	return i >= 0 && strings.EqualFold(strings.TrimSpace(string(line[:i])), name)
+}
+
+// mboxSender returns the envelope sender for the From_ line, preferring the
+// Return-Path over the From header.
+func mboxSender(returnPath, from string) string {
+	if addr := strings.Trim(strings.TrimSpace(returnPath), "<>"); addr != "" && !strings.ContainsAny(addr, " \t") {
+		return addr
+	}
+	if addr, err := mail.ParseAddress(from); err == nil && addr.Address != "" {
+		return addr.Address
+	}
+	return "MAILER-DAEMON"
+}
@@ -0,0 +1,88 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMboxWriterEscaping(t *testing.T) {
+	msg := "From: alice@example.com\r\n" +
+		"X-Keywords: stale\r\n" +
+		"  continued\r\n" +
+		"Subject: hi\r\n" +
+		"\r\n" +
+		"From the start\r\n" +
+		">From quoted\r\n" +
+		"X-Keywords: in the body\r\n" +
+		"no newline"
+	want := "From alice@example.com Tue Nov 17 23:21:38 2009\n" +
+		"X-Keywords: inbox, unread\n" +
+		"From: alice@example.com\n" +
+		"Subject: hi\n" +
+		"\n" +
+		">From the start\n" +
+		">>From quoted\n" +
+		"X-Keywords: in the body\n" +
+		"no newline\n" +
+		"\n"
+
+	var buf bytes.Buffer
+	mw := NewMboxWriter(&buf)
+	mw.TagHeader = "X-Keywords"
+	date := time.Date(2009, time.November, 17, 18, 21, 38, 0, time.FixedZone("EST", -5*60*60))
+	if err := mw.write(strings.NewReader(msg), "alice@example.com", date, []string{"inbox", "unread"}); err != nil {
+		t.Fatalf("mw.write(): unexpected error: %s", err)
+	}
+	if err := mw.Flush(); err != nil {
+		t.Fatalf("mw.Flush(): unexpected error: %s", err)
+	}
+	if got := buf.String(); want != got {
+		t.Errorf("mw.write(): want %q got %q", want, got)
+	}
+}
+
+func TestMboxSender(t *testing.T) {
+	tests := []struct {
+		returnPath, from, want string
+	}{
+		{"<bounce@example.com>", "Alice <alice@example.com>", "bounce@example.com"},
+		{"<>", "Alice <alice@example.com>", "alice@example.com"},
+		{"", "not an address", "MAILER-DAEMON"},
+	}
+	for _, tt := range tests {
+		if got := mboxSender(tt.returnPath, tt.from); tt.want != got {
+			t.Errorf("mboxSender(%q, %q): want %q got %q", tt.returnPath, tt.from, tt.want, got)
+		}
+	}
+}
+
+func TestMboxWriteQuery(t *testing.T) {
+	db, err := Open(dbPath, DBReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var buf bytes.Buffer
+	mw := NewMboxWriter(&buf)
+	mw.Stream = true
+	query := db.NewQuery("subject:\"Introducing myself\"")
+	if err := mw.WriteQuery(query); err != nil {
+		t.Fatalf("mw.WriteQuery(): unexpected error: %s", err)
+	}
+	var count int
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(line, "From ") {
+			count++
+		}
+	}
+	if want, got := query.CountMessages(), count; want != got {
+		t.Errorf("mw.WriteQuery(): want %d From_ lines got %d", want, got)
+	}
+}
@@ -0,0 +1,135 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"bytes"
+	"io"
+	"net/mail"
+)
+
+// ImportDuplicates decides what to do with messages already in the
+// database.
+type ImportDuplicates int
+
+const (
+	// SkipDuplicates leaves out messages whose Message-ID is already in
+	// the database.
+	SkipDuplicates ImportDuplicates = iota
+
+	// MergeDuplicates delivers them anyway, adding the file to the
+	// existing message, and applies the import tags to it.
+	MergeDuplicates
+)
+
+// ImportOptions controls the behaviour of DB.ImportMbox.
+type ImportOptions struct {
+	InsertOptions
+
+	Format MboxFormat
+
+	// Tags holds the tag operations applied to imported messages, in the
+	// syntax accepted by ParseTagOps, e.g. "+archive -inbox -unread". As
+	// with DB.Insert, new messages get the tags in new.tags first.
+	Tags string
+
+	Duplicates ImportDuplicates
+
+	// Progress, if set, is called after each message of the mbox.
+	Progress func(*ImportReport)
+}
+
+// BadMessage is a message of an mbox which could not be imported.
+type BadMessage struct {
+	// Line is the line number of its From_ line in the mbox.
+	Line int
+	Err  error
+}
+
+// ImportReport summarizes the progress of DB.ImportMbox.
+type ImportReport struct {
+	// Read is the number of messages read from the mbox so far.
+	Read int
+
+	// Imported is the number of new messages added, Merged the number of
+	// files added to existing messages and Skipped the number of
+	// duplicates left out.
+	Imported int
+	Merged   int
+	Skipped  int
+
+	Bad []BadMessage
+}
+
+// ImportMbox delivers every message of the mbox read from r to the maildir
+// folder, relative to the mail root, and indexes it with DB.Insert. opts may
+// be nil.
+//
+// Messages which are not valid email are listed in the report, and the
+// import goes on. Any other error stops it; the messages imported until
+// then stay in the database.
+func (db *DB) ImportMbox(r io.Reader, folder string, opts *ImportOptions) (*ImportReport, error) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+	if _, err := ParseTagOps(opts.Tags); err != nil {
+		return nil, err
+	}
+	report := &ImportReport{}
+	mr := NewMboxReader(r, opts.Format)
+	for {
+		msg, err := mr.Next()
+		if err == io.EOF {
+			return report, nil
+		}
+		if err != nil {
+			return report, err
+		}
+		report.Read++
+		if err := db.importMessage(msg, folder, opts, report); err != nil {
+			return report, err
+		}
+		if opts.Progress != nil {
+			opts.Progress(report)
+		}
+	}
+}
+
+func (db *DB) importMessage(msg *MboxMessage, folder string, opts *ImportOptions, report *ImportReport) error {
+	parsed, err := mail.ReadMessage(bytes.NewReader(msg.Data))
+	if err != nil {
+		report.Bad = append(report.Bad, BadMessage{Line: msg.Line, Err: err})
+		return nil
+	}
+	if opts.Duplicates == SkipDuplicates {
+		if ids := parseReferences(parsed.Header.Get("Message-ID")); len(ids) > 0 {
+			// A ghost message only records that a message imported
+			// earlier referenced this one.
+			found, err := db.FindMessage(ids[0])
+			if err == nil && !found.Flag(MessageFlagGhost) {
+				report.Skipped++
+				return nil
+			}
+			if err != nil && err != ErrNotFound {
+				return err
+			}
+		}
+	}
+
+	res, err := db.Insert(bytes.NewReader(msg.Data), folder, opts.Tags, &opts.InsertOptions)
+	if err == ErrFileNotEmail {
+		report.Bad = append(report.Bad, BadMessage{Line: msg.Line, Err: err})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if res.Duplicate {
+		report.Merged++
+	} else {
+		report.Imported++
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportMbox(t *testing.T) {
+	db, err := Open(dbPath, DBReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	folder := "go-notmuch-import"
+	defer os.RemoveAll(filepath.Join(db.mailRoot(), folder))
+	second := strings.Replace(testInsertMessage, "go-notmuch-insert@", "go-notmuch-import@", 1)
+	mbox := "From alice@example.com Mon Jan  2 15:04:05 2006\n" + testInsertMessage + "\n" +
+		"From alice@example.com Mon Jan  2 15:04:05 2006\n" + testInsertMessage + "\n" +
+		"From bob@example.com Mon Jan  2 15:04:05 2006\nnot a header\n\n" +
+		"From bob@example.com Mon Jan  2 15:04:05 2006\n" + second
+	defer func() {
+		for _, id := range []string{"go-notmuch-insert@example.com", "go-notmuch-import@example.com"} {
+			if msg, err := db.FindMessage(id); err == nil {
+				for _, name := range msg.Filenames().slice() {
+					db.RemoveMessage(name)
+				}
+			}
+		}
+	}()
+
+	progress := 0
+	opts := &ImportOptions{
+		InsertOptions: InsertOptions{CreateFolder: true},
+		Tags:          "+go-notmuch-test",
+		Progress:      func(*ImportReport) { progress++ },
+	}
+	report, err := db.ImportMbox(strings.NewReader(mbox), folder, opts)
+	if err != nil {
+		t.Fatalf("db.ImportMbox(): unexpected error: %s", err)
+	}
+	if want, got := 4, progress; want != got {
+		t.Errorf("db.ImportMbox(): want %d progress calls got %d", want, got)
+	}
+	if report.Read != 4 || report.Imported != 2 || report.Skipped != 1 || report.Merged != 0 {
+		t.Errorf("db.ImportMbox(): want 4 read, 2 imported and 1 skipped got %+v", report)
+	}
+	if len(report.Bad) != 1 || report.Bad[0].Line != 19 {
+		t.Errorf("db.ImportMbox(): want a bad message at line 19 got %+v", report.Bad)
+	}
+	if want, got := 2, db.NewQuery("tag:go-notmuch-test").CountMessages(); want != got {
+		t.Errorf("db.ImportMbox(): want %d messages tagged got %d", want, got)
+	}
+
+	opts.Duplicates = MergeDuplicates
+	report, err = db.ImportMbox(strings.NewReader(mbox), folder, opts)
+	if err != nil {
+		t.Fatalf("db.ImportMbox(merge): unexpected error: %s", err)
+	}
+	if report.Merged != 3 || report.Imported != 0 {
+		t.Errorf("db.ImportMbox(merge): want 3 merged got %+v", report)
+	}
+}
+
+func TestImportMboxReplyFirst(t *testing.T) {
+	db, err := Open(dbPath, DBReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	folder := "go-notmuch-import"
+	defer os.RemoveAll(filepath.Join(db.mailRoot(), folder))
+	parent := strings.Replace(testInsertMessage, "go-notmuch-insert@", "go-notmuch-import-parent@", 1)
+	reply := strings.Replace(testInsertMessage, "Message-ID: <go-notmuch-insert@example.com>\n",
+		"Message-ID: <go-notmuch-import-reply@example.com>\nIn-Reply-To: <go-notmuch-import-parent@example.com>\n", 1)
+	// The reply comes first, so the parent is a ghost message when read.
+	mbox := "From alice@example.com Mon Jan  2 15:04:05 2006\n" + reply + "\n" +
+		"From alice@example.com Mon Jan  2 15:04:05 2006\n" + parent
+	ids := []string{"go-notmuch-import-reply@example.com", "go-notmuch-import-parent@example.com"}
+	defer func() {
+		for _, id := range ids {
+			if msg, err := db.FindMessage(id); err == nil {
+				for _, name := range msg.Filenames().slice() {
+					db.RemoveMessage(name)
+				}
+			}
+		}
+	}()
+
+	opts := &ImportOptions{InsertOptions: InsertOptions{CreateFolder: true}}
+	report, err := db.ImportMbox(strings.NewReader(mbox), folder, opts)
+	if err != nil {
+		t.Fatalf("db.ImportMbox(): unexpected error: %s", err)
+	}
+	if report.Imported != 2 || report.Skipped != 0 {
+		t.Errorf("db.ImportMbox(): want 2 imported got %+v", report)
+	}
+	for _, id := range ids {
+		msg, err := db.FindMessage(id)
+		if err != nil {
+			t.Errorf("db.FindMessage(%q): unexpected error: %s", id, err)
+		} else if msg.Flag(MessageFlagGhost) {
+			t.Errorf("db.FindMessage(%q): want a message got a ghost", id)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// MboxFormat is a variant of the mbox format.
+type MboxFormat int
+
+const (
+	// MboxRD escapes every line matching ^>*From  with an extra '>'.
+	MboxRD MboxFormat = iota
+
+	// MboxO only escapes lines starting with "From ". When reading, lines
+	// starting with ">From " are unescaped, as most readers do, since
+	// they are indistinguishable from escaped ones.
+	MboxO
+
+	// MboxCL2 does not escape anything, but gives the length of each
+	// message body in a Content-Length header.
+	MboxCL2
+)
+
+// MboxMessage is a message read from an mbox.
+type MboxMessage struct {
+	// From is the From_ line starting the message, without "From " and
+	// the line ending.
+	From string
+
+	// Data holds the message, unescaped.
+	Data []byte
+
+	// Line is the line number of the From_ line.
+	Line int
+}
+
+// MboxReader splits an mbox into messages.
+type MboxReader struct {
+	br     *bufio.Reader
+	format MboxFormat
+	line   int
+
+	// next holds the From_ line of the next message, once read.
+	next []byte
+	err  error
+
+	// raw is the length of the last line read, before line ending
+	// conversion.
+	raw int
+}
+
+// NewMboxReader returns a reader of the mbox in format read from r.
+func NewMboxReader(r io.Reader, format MboxFormat) *MboxReader {
+	return &MboxReader{br: bufio.NewReader(r), format: format}
+}
+
+// readLine returns the next line, including its line ending, converted to
+// LF.
+func (mr *MboxReader) readLine() ([]byte, error) {
+	line, err := mr.br.ReadBytes('\n')
+	mr.raw = len(line)
+	if len(line) > 0 {
+		mr.line++
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			line = append(line[:len(line)-2], '\n')
+		}
+		return line, nil
+	}
+	return nil, err
+}
+
+// Next returns the next message of the mbox, or io.EOF once all have been
+// read. An error other than io.EOF makes all later calls fail too.
+func (mr *MboxReader) Next() (*MboxMessage, error) {
+	if mr.err != nil {
+		return nil, mr.err
+	}
+	if mr.next == nil {
+		// Skip blank lines up to the first From_ line.
+		for {
+			line, err := mr.readLine()
+			if err != nil {
+				mr.err = err
+				return nil, err
+			}
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			if !bytes.HasPrefix(line, []byte("From ")) {
+				mr.err = fmt.Errorf("line %d: not an mbox: missing From_ line", mr.line)
+				return nil, mr.err
+			}
+			mr.next = line
+			break
+		}
+	}
+
+	msg := &MboxMessage{
+		From: strings.TrimRight(string(mr.next[len("From "):]), "\n"),
+		Line: mr.line,
+	}
+	mr.next = nil
+	var err error
+	if mr.format == MboxCL2 {
+		msg.Data, err = mr.readCL2()
+	} else {
+		msg.Data, err = mr.readEscaped()
+	}
+	if err != nil && err != io.EOF {
+		mr.err = err
+		return nil, err
+	}
+	return msg, nil
+}
+
+// readEscaped reads a message up to the next From_ line, unescaping it.
+func (mr *MboxReader) readEscaped() ([]byte, error) {
+	var data []byte
+	for {
+		line, err := mr.readLine()
+		if err != nil {
+			return trimSeparator(data), err
+		}
+		if bytes.HasPrefix(line, []byte("From ")) {
+			mr.next = line
+			return trimSeparator(data), nil
+		}
+		data = append(data, mr.unescape(line)...)
+	}
+}
+
+func (mr *MboxReader) unescape(line []byte) []byte {
+	if len(line) == 0 || line[0] != '>' || mr.format == MboxCL2 {
+		return line
+	}
+	if mr.format == MboxO {
+		if bytes.HasPrefix(line, []byte(">From ")) {
+			return line[1:]
+		}
+		return line
+	}
+	if isFromLine(line) {
+		return line[1:]
+	}
+	return line
+}
+
+// readCL2 reads a message whose body length is given by its Content-Length
+// header. Without one, it falls back to looking for the next From_ line.
+func (mr *MboxReader) readCL2() ([]byte, error) {
+	var data []byte
+	length := -1
+	for {
+		line, err := mr.readLine()
+		if err != nil {
+			return data, err
+		}
+		if bytes.HasPrefix(line, []byte("From ")) && len(data) == 0 {
+			mr.next = line
+			return nil, nil
+		}
+		data = append(data, line...)
+		if isHeader(line, "Content-Length") {
+			value := line[bytes.IndexByte(line, ':')+1:]
+			if n, err := strconv.Atoi(string(bytes.TrimSpace(value))); err == nil && n >= 0 {
+				length = n
+			}
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			break
+		}
+	}
+	if length < 0 {
+		body, err := mr.readEscaped()
+		return append(data, body...), err
+	}
+
+	// Content-Length counts the bytes in the file, whatever the line
+	// endings. A truncated last message is returned as is.
+	for read := 0; read < length; read += mr.raw {
+		line, err := mr.readLine()
+		if err != nil {
+			return data, err
+		}
+		data = append(data, line...)
+	}
+	// Skip the blank lines separating messages. If something else comes
+	// before the next From_ line, Content-Length was wrong: fall back to
+	// looking for the From_ line.
+	for {
+		line, err := mr.readLine()
+		if err != nil {
+			return data, err
+		}
+		if bytes.HasPrefix(line, []byte("From ")) {
+			mr.next = line
+			return data, nil
+		}
+		if len(bytes.TrimSpace(line)) != 0 {
+			rest, err := mr.readEscaped()
+			return append(append(data, line...), rest...), err
+		}
+	}
+}
+
+// trimSeparator removes the blank line preceding a From_ line, which is
+// part of the mbox, not of the message.
+func trimSeparator(data []byte) []byte {
+	if bytes.HasSuffix(data, []byte("\n\n")) {
+		return data[:len(data)-1]
+	}
+	return data
+}
@@ -0,0 +1,81 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readMbox(t *testing.T, mbox string, format MboxFormat) []*MboxMessage {
+	mr := NewMboxReader(strings.NewReader(mbox), format)
+	var msgs []*MboxMessage
+	for {
+		msg, err := mr.Next()
+		if err == io.EOF {
+			return msgs
+		}
+		if err != nil {
+			t.Fatalf("mr.Next(): unexpected error: %s", err)
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func checkMbox(t *testing.T, want, got []*MboxMessage) {
+	if len(want) != len(got) {
+		t.Errorf("want %d messages got %d", len(want), len(got))
+		return
+	}
+	for i := range want {
+		if !reflect.DeepEqual(want[i], got[i]) {
+			t.Errorf("message %d: want %q %q line %d got %q %q line %d", i,
+				want[i].From, want[i].Data, want[i].Line, got[i].From, got[i].Data, got[i].Line)
+		}
+	}
+}
+
+func TestMboxReader(t *testing.T) {
+	mbox := "\nFrom alice@example.com Mon Jan  2 15:04:05 2006\n" +
+		"Subject: one\n\n>From here\n>>From there\n\n" +
+		"From bob@example.com Mon Jan  2 15:04:06 2006\r\n" +
+		"Subject: two\r\n\r\nbody\r\n"
+	tests := []struct {
+		format MboxFormat
+		first  string
+	}{
+		{MboxRD, "Subject: one\n\nFrom here\n>From there\n"},
+		{MboxO, "Subject: one\n\nFrom here\n>>From there\n"},
+	}
+	for _, tt := range tests {
+		msgs := readMbox(t, mbox, tt.format)
+		want := []*MboxMessage{
+			{From: "alice@example.com Mon Jan  2 15:04:05 2006", Data: []byte(tt.first), Line: 2},
+			{From: "bob@example.com Mon Jan  2 15:04:06 2006", Data: []byte("Subject: two\n\nbody\n"), Line: 8},
+		}
+		checkMbox(t, want, msgs)
+	}
+
+	mr := NewMboxReader(strings.NewReader("Subject: no From_ line\n"), MboxRD)
+	if _, err := mr.Next(); err == nil || err == io.EOF {
+		t.Errorf("mr.Next() without From_ line: want an error got %v", err)
+	}
+}
+
+func TestMboxReaderCL2(t *testing.T) {
+	body := "From the start\n\nFrom the end\n"
+	mbox := "From alice@example.com Mon Jan  2 15:04:05 2006\n" +
+		"Subject: one\nContent-Length: 29\n\n" + body + "\n" +
+		"From bob@example.com Mon Jan  2 15:04:06 2006\n" +
+		"Subject: two\n\n>From no length\n"
+	msgs := readMbox(t, mbox, MboxCL2)
+	want := []*MboxMessage{
+		{From: "alice@example.com Mon Jan  2 15:04:05 2006", Data: []byte("Subject: one\nContent-Length: 29\n\n" + body), Line: 1},
+		{From: "bob@example.com Mon Jan  2 15:04:06 2006", Data: []byte("Subject: two\n\n>From no length\n"), Line: 9},
+	}
+	checkMbox(t, want, msgs)
+}
@@ -16,10 +16,33 @@ import (
 // Message represents a notmuch message.
 type Message cStruct
 
+// MessageFlag represents a flag notmuch keeps on a message.
+type MessageFlag C.notmuch_message_flag_t
+
+const (
+	// MessageFlagMatch is set if the message matched the query it was
+	// retrieved with.
+	MessageFlagMatch MessageFlag = C.NOTMUCH_MESSAGE_FLAG_MATCH
+
+	// MessageFlagExcluded is set if the message carries one of the query's
+	// excluded tags.
+	MessageFlagExcluded MessageFlag = C.NOTMUCH_MESSAGE_FLAG_EXCLUDED
+
+	// MessageFlagGhost is set if the message is a ghost: a placeholder
+	// notmuch keeps for a message which other messages refer to, but which
+	// is not in the database itself.
+	MessageFlagGhost MessageFlag = C.NOTMUCH_MESSAGE_FLAG_GHOST
+)
+
 func (m *Message) toC() *C.notmuch_message_t {
 	return (*C.notmuch_message_t)(m.cptr)
 }
 
+// db returns the database the message was retrieved from.
+func (m *Message) db() *DB {
+	return (*DB)((*cStruct)(m).root())
+}
+
 func (m *Message) Close() error {
 	return (*cStruct)(m).doClose(func() error {
 		C.notmuch_message_destroy(m.toC())
@@ -72,6 +95,12 @@ func (m *Message) Filenames() *Filenames {
 	}
 }
 
+// Flag returns true if flag is set on the message.
+func (m *Message) Flag(flag MessageFlag) bool {
+	cbool := C.notmuch_message_get_flag(m.toC(), C.notmuch_message_flag_t(flag))
+	return int(cbool) != 0
+}
+
 // Date returns the date of the message.
 func (m *Message) Date() time.Time {
 	ctime := C.notmuch_message_get_date(m.toC())
@@ -136,6 +165,33 @@ func (m *Message) Properties(key string, exact bool) *MessageProperties {
 	return props
 }
 
+// Property returns the value of the property with key. If the message has
+// several values for key, an arbitrary one is returned. It returns
+// ErrNotFound if the message has no value for key.
+func (m *Message) Property(key string) (string, error) {
+	ckey := C.CString(key)
+	defer C.free(unsafe.Pointer(ckey))
+	var cvalue *C.char
+	if err := statusErr(C.notmuch_message_get_property(m.toC(), ckey, &cvalue)); err != nil {
+		return "", err
+	}
+	if cvalue == nil {
+		return "", ErrNotFound
+	}
+	return C.GoString(cvalue), nil
+}
+
+// CountProperties returns the number of values the message has for key.
+func (m *Message) CountProperties(key string) (int, error) {
+	ckey := C.CString(key)
+	defer C.free(unsafe.Pointer(ckey))
+	var ccount C.uint
+	if err := statusErr(C.notmuch_message_count_properties(m.toC(), ckey, &ccount)); err != nil {
+		return 0, err
+	}
+	return int(ccount), nil
+}
+
 // AddProperty adds a property to the message.
 func (m *Message) AddProperty(key string, value string) error {
 	ckey := C.CString(key)
@@ -161,6 +217,12 @@ func (m *Message) RemoveAllProperties(key string) error {
 	return statusErr(C.notmuch_message_remove_all_properties(m.toC(), ckey))
 }
 
+// removeAllProperties removes all properties from the message, whatever
+// their key.
+func (m *Message) removeAllProperties() error {
+	return statusErr(C.notmuch_message_remove_all_properties(m.toC(), nil))
+}
+
 // Atomic allows a transactional change of tags to the message.
 func (m *Message) Atomic(callback func(*Message)) error {
 	if err := statusErr(C.notmuch_message_freeze(m.toC())); err != nil {
@@ -1,7 +1,9 @@
 package notmuch
 
 import (
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestMessagesProperties(t *testing.T) {
@@ -37,3 +39,59 @@ func TestMessagesProperties(t *testing.T) {
 
 	t.Fatalf("couldn't find expected property")
 }
+
+func TestMessageTypedProperties(t *testing.T) {
+	db, err := Open(dbPath, DBReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	msg, err := db.FindMessage("1258471718-6781-2-git-send-email-dottedmag@dottedmag.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := PropertyKey("go-notmuch-test", "value")
+	defer msg.RemoveAllProperties(key)
+	if _, err := msg.Property(key); err != ErrNotFound {
+		t.Errorf("msg.Property(): want error %q got %v", ErrNotFound, err)
+	}
+
+	if err := msg.SetProperties(key, []string{"a", "b"}); err != nil {
+		t.Fatalf("msg.SetProperties(): unexpected error: %s", err)
+	}
+	if count, err := msg.CountProperties(key); err != nil || count != 2 {
+		t.Errorf("msg.CountProperties(): want 2 got %d (error %v)", count, err)
+	}
+
+	if err := msg.SetPropertyInt(key, -42); err != nil {
+		t.Fatalf("msg.SetPropertyInt(): unexpected error: %s", err)
+	}
+	if count, err := msg.CountProperties(key); err != nil || count != 1 {
+		t.Errorf("msg.CountProperties() after SetPropertyInt: want 1 got %d (error %v)", count, err)
+	}
+	if n, err := msg.PropertyInt(key); err != nil || n != -42 {
+		t.Errorf("msg.PropertyInt(): want -42 got %d (error %v)", n, err)
+	}
+
+	when := time.Date(2020, 2, 29, 12, 30, 0, 500, time.FixedZone("", 3600))
+	if err := msg.SetPropertyTime(key, when); err != nil {
+		t.Fatalf("msg.SetPropertyTime(): unexpected error: %s", err)
+	}
+	if got, err := msg.PropertyTime(key); err != nil || !got.Equal(when) {
+		t.Errorf("msg.PropertyTime(): want %s got %s (error %v)", when, got, err)
+	}
+
+	type state struct {
+		Step  int
+		Notes []string
+	}
+	want := state{Step: 2, Notes: []string{"x"}}
+	if err := msg.SetPropertyJSON(key, want); err != nil {
+		t.Fatalf("msg.SetPropertyJSON(): unexpected error: %s", err)
+	}
+	var got state
+	if err := msg.PropertyJSON(key, &got); err != nil || !reflect.DeepEqual(want, got) {
+		t.Errorf("msg.PropertyJSON(): want %+v got %+v (error %v)", want, got, err)
+	}
+}
@@ -0,0 +1,74 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"net/url"
+	"strings"
+)
+
+// NormalizeMessageID returns the bare message ID, as expected by
+// DB.FindMessage, from any of the forms message IDs are commonly passed
+// around in:
+//
+//	<id@example.com>           as in a Message-ID header
+//	id:id@example.com          as in a notmuch query
+//	id:"id@example.com"        likewise, quoted
+//	mid:id%40example.com       an RFC 2392 URL
+//	"<id@example.com>"         any of the above, quoted
+func NormalizeMessageID(id string) string {
+	id = unquoteID(strings.TrimSpace(id))
+	switch {
+	case hasPrefixFold(id, "id:"):
+		id = unquoteID(id[len("id:"):])
+	case hasPrefixFold(id, "mid:"):
+		id = id[len("mid:"):]
+		// A mid: URL may go on to name a part of the message.
+		if i := strings.IndexByte(id, '/'); i >= 0 {
+			id = id[:i]
+		}
+		if unescaped, err := url.PathUnescape(id); err == nil {
+			id = unescaped
+		}
+	}
+	id = strings.TrimSpace(id)
+	if strings.HasPrefix(id, "<") && strings.HasSuffix(id, ">") {
+		id = id[1 : len(id)-1]
+	}
+	return strings.TrimSpace(id)
+}
+
+// parseReferences returns the message IDs in the value of a References or
+// In-Reply-To header, i.e. everything between angle brackets.
+func parseReferences(value string) []string {
+	var ids []string
+	for {
+		start := strings.IndexByte(value, '<')
+		if start < 0 {
+			return ids
+		}
+		end := strings.IndexByte(value[start:], '>')
+		if end < 0 {
+			return ids
+		}
+		if id := strings.TrimSpace(value[start+1 : start+end]); id != "" {
+			ids = append(ids, id)
+		}
+		value = value[start+end+1:]
+	}
+}
+
+// unquoteID strips the double quotes around id, undoing the doubling of
+// quotes inside that notmuch uses in queries.
+func unquoteID(id string) string {
+	if len(id) >= 2 && strings.HasPrefix(id, `"`) && strings.HasSuffix(id, `"`) {
+		return strings.Replace(id[1:len(id)-1], `""`, `"`, -1)
+	}
+	return id
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
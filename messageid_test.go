@@ -0,0 +1,93 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeMessageID(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"id@example.com", "id@example.com"},
+		{"  <id@example.com>\n", "id@example.com"},
+		{"id:id@example.com", "id@example.com"},
+		{"ID:<id@example.com>", "id@example.com"},
+		{`id:"odd ""id""@example.com"`, `odd "id"@example.com`},
+		{"mid:id%40example.com", "id@example.com"},
+		{"mid:%3Cid%40example.com%3E/part%40example.com", "id@example.com"},
+		{`"<id@example.com>"`, "id@example.com"},
+		{`"id:id@example.com"`, "id@example.com"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeMessageID(tt.in); tt.want != got {
+			t.Errorf("NormalizeMessageID(%q): want %q got %q", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestFindMessages(t *testing.T) {
+	db, err := Open(dbPath, DBReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	known := []string{
+		"<87iqd9rn3l.fsf@vertex.dottedmag>",
+		"id:20091118002059.067214ed@hikari",
+		"mid:1258471718-6781-2-git-send-email-dottedmag%40dottedmag.net",
+	}
+	wantFound := []string{
+		"87iqd9rn3l.fsf@vertex.dottedmag",
+		"20091118002059.067214ed@hikari",
+		"1258471718-6781-2-git-send-email-dottedmag@dottedmag.net",
+	}
+
+	// Ghost messages, referenced but not in the database, are missing too.
+	var ghost string
+	all, err := db.MissingMessages("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mm := range all {
+		if mm.Ghost {
+			ghost = mm.ID
+			break
+		}
+	}
+	if ghost == "" {
+		t.Fatal("db.MissingMessages(): want a ghost message in the test database got none")
+	}
+
+	// Once with few enough IDs to look them up one by one, once with
+	// enough to batch them into a query.
+	for _, n := range []int{1, findMessagesBatchMin} {
+		var unknown []string
+		for i := 0; i < n; i++ {
+			unknown = append(unknown, fmt.Sprintf("notfound-%d@example.com", i))
+		}
+		unknown = append(unknown, ghost)
+		ids := append(append([]string{}, known...), unknown...)
+		ids = append(ids, known...)
+		found, missing, err := db.FindMessages(ids)
+		if err != nil {
+			t.Fatalf("db.FindMessages(%q): unexpected error: %s", ids, err)
+		}
+		var gotFound []string
+		for _, msg := range found {
+			gotFound = append(gotFound, msg.ID())
+		}
+		if !reflect.DeepEqual(wantFound, gotFound) {
+			t.Errorf("db.FindMessages(%q): want found %q got %q", ids, wantFound, gotFound)
+		}
+		if !reflect.DeepEqual(unknown, missing) {
+			t.Errorf("db.FindMessages(%q): want missing %q got %q", ids, unknown, missing)
+		}
+	}
+}
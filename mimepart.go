@@ -0,0 +1,246 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxMIMEDepth bounds the nesting of multipart and message/rfc822 parts we
+// descend into. Anything deeper is treated as an opaque leaf.
+const maxMIMEDepth = 32
+
+// mimeMessage is a message file parsed into its MIME structure. notmuch
+// itself only indexes message files; everything that needs the body goes
+// through here.
+type mimeMessage struct {
+	header textproto.MIMEHeader
+	root   *mimePart
+}
+
+// mimePart is a node in the MIME tree of a message.
+//
+// Leaf parts hold their body with the content-transfer-encoding removed.
+// Multipart parts hold their children in parts, and message/rfc822 parts
+// hold the embedded message in message.
+type mimePart struct {
+	header    textproto.MIMEHeader
+	mediaType string
+	params    map[string]string
+	body      []byte
+	parts     []*mimePart
+	message   *mimeMessage
+}
+
+// readMIMEFile parses the message stored in filename.
+func readMIMEFile(filename string) (*mimeMessage, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readMIMEMessage(f, 0)
+}
+
+func readMIMEMessage(r io.Reader, depth int) (*mimeMessage, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+	header := textproto.MIMEHeader(msg.Header)
+	root, err := readMIMEPart(header, msg.Body, depth)
+	if err != nil {
+		return nil, err
+	}
+	return &mimeMessage{header: header, root: root}, nil
+}
+
+func readMIMEPart(header textproto.MIMEHeader, body io.Reader, depth int) (*mimePart, error) {
+	p := &mimePart{header: header}
+	p.mediaType, p.params = parseContentType(header.Get("Content-Type"))
+
+	if depth < maxMIMEDepth && p.isMultipart() {
+		p.parts = []*mimePart{}
+		boundary := p.params["boundary"]
+		if boundary == "" {
+			return p, nil
+		}
+		mr := multipart.NewReader(body, boundary)
+		for {
+			// NextPart decodes quoted-printable parts itself, and removes
+			// their Content-Transfer-Encoding header so that they are not
+			// decoded twice. Other encodings are left to us.
+			part, err := mr.NextPart()
+			if err != nil {
+				// Either the end of the multipart, or a truncated or
+				// otherwise broken one. Like notmuch, we keep whatever
+				// parts we managed to read.
+				return p, nil
+			}
+			child, err := readMIMEPart(part.Header, part, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			p.parts = append(p.parts, child)
+		}
+	}
+
+	decoded := decodeTransferEncoding(body, header.Get("Content-Transfer-Encoding"))
+	if depth < maxMIMEDepth && p.mediaType == "message/rfc822" {
+		data, _ := ioutil.ReadAll(decoded)
+		if m, err := readMIMEMessage(strings.NewReader(string(data)), depth+1); err == nil {
+			p.message = m
+			return p, nil
+		}
+		p.body = data
+		return p, nil
+	}
+
+	// A broken base64 or quoted-printable body still yields whatever could
+	// be decoded before the error, which is the best we can do.
+	p.body, _ = ioutil.ReadAll(decoded)
+	return p, nil
+}
+
+// parseContentType parses the value of a Content-Type header, falling back
+// to text/plain as RFC 2045 requires for missing or unparseable values.
+func parseContentType(value string) (string, map[string]string) {
+	if value == "" {
+		return "text/plain", map[string]string{}
+	}
+	mediaType, params, err := mime.ParseMediaType(value)
+	if err != nil && mediaType == "" {
+		return "text/plain", map[string]string{}
+	}
+	return strings.ToLower(mediaType), params
+}
+
+func decodeTransferEncoding(r io.Reader, encoding string) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	default:
+		return r
+	}
+}
+
+func (p *mimePart) isMultipart() bool {
+	return strings.HasPrefix(p.mediaType, "multipart/")
+}
+
+func (p *mimePart) isText() bool {
+	return strings.HasPrefix(p.mediaType, "text/")
+}
+
+// disposition returns the lowercased disposition type of the part, or "" if
+// it has no Content-Disposition header.
+func (p *mimePart) disposition() string {
+	value := p.header.Get("Content-Disposition")
+	if value == "" {
+		return ""
+	}
+	disposition, _, err := mime.ParseMediaType(value)
+	if err != nil && disposition == "" {
+		return ""
+	}
+	return strings.ToLower(disposition)
+}
+
+// filename returns the filename of the part, taken from the
+// Content-Disposition header or, failing that, from the name parameter of
+// the Content-Type header.
+func (p *mimePart) filename() string {
+	if value := p.header.Get("Content-Disposition"); value != "" {
+		if _, params, err := mime.ParseMediaType(value); err == nil && params["filename"] != "" {
+			return params["filename"]
+		}
+	}
+	return decodeHeader(p.params["name"])
+}
+
+// text returns the body of the part converted to UTF-8.
+func (p *mimePart) text() string {
+	return decodeCharset(p.body, p.params["charset"])
+}
+
+// walk calls f on p and every part below it, depth first, descending into
+// embedded messages.
+func (p *mimePart) walk(f func(*mimePart)) {
+	f(p)
+	for _, child := range p.parts {
+		child.walk(f)
+	}
+	if p.message != nil {
+		p.message.root.walk(f)
+	}
+}
+
+// decodeCharset converts b from charset to UTF-8. Only UTF-8, US-ASCII and
+// ISO-8859-1 are really converted; anything else is passed through with
+// invalid sequences replaced.
+func decodeCharset(b []byte, charset string) string {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "iso_8859-1":
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		return string(runes)
+	default:
+		return validUTF8(b)
+	}
+}
+
+// validUTF8 returns b as a string, with invalid UTF-8 sequences replaced by
+// U+FFFD.
+func validUTF8(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	var sb strings.Builder
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		if r == utf8.RuneError && size == 1 {
+			sb.WriteRune(utf8.RuneError)
+		} else {
+			sb.Write(b[:size])
+		}
+		b = b[size:]
+	}
+	return sb.String()
+}
+
+// headerWordDecoder decodes RFC 2047 encoded-words in headers.
+var headerWordDecoder = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		b, err := ioutil.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		return strings.NewReader(decodeCharset(b, charset)), nil
+	},
+}
+
+// decodeHeader decodes RFC 2047 encoded-words in a header value. Values
+// which fail to decode are returned unchanged.
+func decodeHeader(value string) string {
+	decoded, err := headerWordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
@@ -0,0 +1,86 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+// MissingMessage is a message which is referenced by messages in the
+// database, through their In-Reply-To or References headers, but which is
+// not in the database itself.
+type MissingMessage struct {
+	ID string
+
+	// Ghost is true if notmuch keeps a ghost message for the ID: a
+	// placeholder recording the message's thread until it turns up.
+	Ghost bool
+
+	// ReferencedBy lists the IDs of the messages referencing the missing
+	// message.
+	ReferencedBy []string
+}
+
+// MissingMessages returns the messages referenced by messages of the thread
+// which are not in the database, in the order they are first referenced.
+func (t *Thread) MissingMessages() ([]*MissingMessage, error) {
+	return t.db().missingMessages(t.Messages())
+}
+
+// MissingMessages returns the messages referenced by the messages matching
+// query which are not in the database, in the order they are first
+// referenced.
+func (db *DB) MissingMessages(query string) ([]*MissingMessage, error) {
+	q := db.NewQuery(query)
+	q.SetExcludeScheme(EXCLUDE_FALSE)
+	msgs, err := q.Messages()
+	if err != nil {
+		return nil, err
+	}
+	return db.missingMessages(msgs)
+}
+
+func (db *DB) missingMessages(msgs *Messages) ([]*MissingMessage, error) {
+	present := map[string]bool{}
+	referenced := map[string]*MissingMessage{}
+	var order []*MissingMessage
+
+	var msg *Message
+	for msgs.Next(&msg) {
+		id := msg.ID()
+		present[id] = true
+		refs := parseReferences(msg.Header("In-Reply-To"))
+		refs = append(refs, parseReferences(msg.Header("References"))...)
+		for _, ref := range refs {
+			if ref == id {
+				continue
+			}
+			mm, ok := referenced[ref]
+			if !ok {
+				mm = &MissingMessage{ID: ref}
+				referenced[ref] = mm
+				order = append(order, mm)
+			}
+			if n := len(mm.ReferencedBy); n == 0 || mm.ReferencedBy[n-1] != id {
+				mm.ReferencedBy = append(mm.ReferencedBy, id)
+			}
+		}
+	}
+
+	var missing []*MissingMessage
+	for _, mm := range order {
+		if present[mm.ID] {
+			continue
+		}
+		found, err := db.FindMessage(mm.ID)
+		switch {
+		case err == ErrNotFound:
+		case err != nil:
+			return nil, err
+		case found.Flag(MessageFlagGhost):
+			mm.Ghost = true
+		default:
+			continue
+		}
+		missing = append(missing, mm)
+	}
+	return missing, nil
+}
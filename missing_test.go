@@ -0,0 +1,70 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseReferences(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"<a@example.com>", []string{"a@example.com"}},
+		{"<a@example.com>\n\t<b@example.com> <>", []string{"a@example.com", "b@example.com"}},
+		{"Your message of Tue <a@example.com> (junk", []string{"a@example.com"}},
+		{"<a@example.com> <unterminated", []string{"a@example.com"}},
+	}
+	for _, tt := range tests {
+		if got := parseReferences(tt.in); !reflect.DeepEqual(tt.want, got) {
+			t.Errorf("parseReferences(%q): want %q got %q", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestThreadMissingMessages(t *testing.T) {
+	db, err := Open(dbPath, DBReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	thread, err := firstThread(db, "subject:\"Introducing myself\"")
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, err := thread.MissingMessages()
+	if err != nil {
+		t.Fatalf("thread.MissingMessages(): unexpected error: %s", err)
+	}
+	if len(missing) != 0 {
+		t.Errorf("thread.MissingMessages(): want none got %d, first %q", len(missing), missing[0].ID)
+	}
+}
+
+func TestDBMissingMessages(t *testing.T) {
+	db, err := Open(dbPath, DBReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	missing, err := db.MissingMessages("")
+	if err != nil {
+		t.Fatalf("db.MissingMessages(%q): unexpected error: %s", "", err)
+	}
+	for _, mm := range missing {
+		msg, err := db.FindMessage(mm.ID)
+		if err != ErrNotFound && (err != nil || !msg.Flag(MessageFlagGhost)) {
+			t.Errorf("db.MissingMessages(): %q is in the database", mm.ID)
+		}
+		if len(mm.ReferencedBy) == 0 {
+			t.Errorf("db.MissingMessages(): %q is referenced by nothing", mm.ID)
+		}
+	}
+}
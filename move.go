@@ -0,0 +1,130 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"path/filepath"
+
+	"github.com/zenhack/go.notmuch/maildir"
+)
+
+// MoveOptions controls the behaviour of Message.MoveTo and DB.Move.
+type MoveOptions struct {
+	// CreateFolder creates the destination folder, as a maildir, if it
+	// does not exist.
+	CreateFolder bool
+}
+
+// MoveTo moves every file of the message to the maildir folder, relative to
+// the mail root, keeping their names, including their maildir flags, and
+// their new or cur subdirectory. Files outside of maildirs go to cur if
+// their name carries flags, new otherwise. Files already in folder are left
+// alone. opts may be nil.
+//
+// Each file is linked under its new name, the new filename replaces the
+// old one in the database inside an atomic section, and only then is the
+// old name unlinked. If the process dies midway, the file can always be
+// found under one of its names.
+func (m *Message) MoveTo(folder string, opts *MoveOptions) error {
+	md, err := m.db().openFolder(folder, opts)
+	if err != nil {
+		return err
+	}
+	_, err = m.moveTo(md)
+	return err
+}
+
+// Move moves the files of every message matching query to the maildir
+// folder, like Message.MoveTo, and returns the number of files moved. opts
+// may be nil.
+func (db *DB) Move(query string, folder string, opts *MoveOptions) (int, error) {
+	md, err := db.openFolder(folder, opts)
+	if err != nil {
+		return 0, err
+	}
+	q := db.NewQuery(query)
+	q.SetExcludeScheme(EXCLUDE_FALSE)
+	q.SetSortScheme(SORT_UNSORTED)
+	msgs, err := q.Messages()
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	var msg *Message
+	for msgs.Next(&msg) {
+		n, err := msg.moveTo(md)
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+func (db *DB) openFolder(folder string, opts *MoveOptions) (maildir.Dir, error) {
+	if opts == nil {
+		opts = &MoveOptions{}
+	}
+	dir, err := db.folderPath(folder)
+	if err != nil {
+		return "", err
+	}
+	if opts.CreateFolder {
+		return maildir.Create(dir)
+	}
+	return maildir.Open(dir)
+}
+
+// moveTo moves the files of m to md and returns the number of files moved.
+func (m *Message) moveTo(md maildir.Dir) (int, error) {
+	var count int
+	for _, old := range m.Filenames().slice() {
+		name := filepath.Base(old)
+		sub := filepath.Base(filepath.Dir(old))
+		if sub != maildir.New && sub != maildir.Cur {
+			sub = maildir.New
+			if _, info := maildir.SplitInfo(name); info != "" {
+				sub = maildir.Cur
+			}
+		} else if filepath.Dir(filepath.Dir(old)) == md.Path() {
+			continue
+		}
+		dest := filepath.Join(md.Path(), sub, name)
+		if err := m.db().renameFile(old, dest); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// renameFile renames the message file at old to dest, on disk and in the
+// database.
+func (db *DB) renameFile(old, dest string) error {
+	if err := maildir.MoveNoReplace(old, dest); err != nil {
+		return err
+	}
+	var renameErr error
+	err := db.Atomic(func(db *DB) {
+		if _, err := db.AddMessage(dest); err != nil && err != ErrDuplicateMessageID {
+			renameErr = err
+			return
+		}
+		// The message still has a filename, dest, so notmuch reports
+		// ErrDuplicateMessageID on success.
+		if err := db.RemoveMessage(old); err != nil && err != ErrDuplicateMessageID {
+			renameErr = err
+			db.RemoveMessage(dest)
+		}
+	})
+	if renameErr == nil {
+		renameErr = err
+	}
+	if renameErr != nil {
+		maildir.MoveNoReplace(dest, old)
+		return renameErr
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMoveTo(t *testing.T) {
+	db, err := Open(dbPath, DBReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	defer os.RemoveAll(filepath.Join(db.mailRoot(), "go-notmuch-move"))
+	res, err := db.Insert(strings.NewReader(testInsertMessage), "go-notmuch-move/a", "", &InsertOptions{CreateFolder: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := res.Message
+	defer func() {
+		for _, name := range msg.Filenames().slice() {
+			db.RemoveMessage(name)
+		}
+	}()
+
+	if err := msg.MoveTo("go-notmuch-move/b", nil); err == nil {
+		t.Errorf("msg.MoveTo(missing folder): want an error got nil")
+	}
+	if err := msg.MoveTo("go-notmuch-move/b", &MoveOptions{CreateFolder: true}); err != nil {
+		t.Fatalf("msg.MoveTo(): unexpected error: %s", err)
+	}
+	dest := filepath.Join(db.mailRoot(), "go-notmuch-move/b", filepath.Base(filepath.Dir(res.Path)), filepath.Base(res.Path))
+	if want, got := []string{dest}, msg.Filenames().slice(); !reflect.DeepEqual(want, got) {
+		t.Errorf("msg.Filenames() after MoveTo: want %v got %v", want, got)
+	}
+	if _, err := os.Stat(dest); err != nil {
+		t.Errorf("msg.MoveTo(): want %s to exist got error %s", dest, err)
+	}
+	if _, err := os.Stat(res.Path); !os.IsNotExist(err) {
+		t.Errorf("msg.MoveTo(): want %s removed got error %v", res.Path, err)
+	}
+
+	count, err := db.Move("id:"+msg.ID(), "go-notmuch-move/a", nil)
+	if err != nil {
+		t.Fatalf("db.Move(): unexpected error: %s", err)
+	}
+	if want, got := 1, count; want != got {
+		t.Errorf("db.Move(): want %d files moved got %d", want, got)
+	}
+	if want, got := []string{res.Path}, msg.Filenames().slice(); !reflect.DeepEqual(want, got) {
+		t.Errorf("msg.Filenames() after Move: want %v got %v", want, got)
+	}
+	if count, err := db.Move("id:"+msg.ID(), "go-notmuch-move/a", nil); err != nil || count != 0 {
+		t.Errorf("db.Move() again: want 0 files moved got %d (error %v)", count, err)
+	}
+}
@@ -0,0 +1,139 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+// DefaultMuteTag is the tag marking messages of muted threads.
+const DefaultMuteTag = "muted"
+
+// MuteOptions controls thread muting.
+type MuteOptions struct {
+	TagOptions
+
+	// Tag marks the messages of muted threads. It defaults to
+	// DefaultMuteTag.
+	Tag string
+
+	// Ops holds the tag operations applied to the messages of muted
+	// threads, in the syntax accepted by ParseTagOps. It defaults to
+	// adding Tag and removing inbox, "+muted -inbox".
+	Ops string
+}
+
+// ops returns the tag operations for muting, with the defaults filled in.
+func (opts *MuteOptions) ops() (string, []TagOp, error) {
+	tag := opts.Tag
+	if tag == "" {
+		tag = DefaultMuteTag
+	}
+	ops := opts.Ops
+	if ops == "" {
+		ops = "+" + tag + " -inbox"
+	}
+	parsed, err := ParseTagOps(ops)
+	return tag, parsed, err
+}
+
+// Mute applies the mute operations of opts to every message of the thread.
+// opts may be nil.
+func (t *Thread) Mute(opts *MuteOptions) error {
+	if opts == nil {
+		opts = &MuteOptions{}
+	}
+	_, ops, err := opts.ops()
+	if err != nil {
+		return err
+	}
+	_, err = t.ApplyTagOps(ops, ThreadAll, &opts.TagOptions)
+	return err
+}
+
+// Unmute removes the mute tag from every message of the thread. The other
+// mute operations, such as removing inbox, are not reverted. opts may be
+// nil.
+func (t *Thread) Unmute(opts *MuteOptions) error {
+	if opts == nil {
+		opts = &MuteOptions{}
+	}
+	tag, _, err := opts.ops()
+	if err != nil {
+		return err
+	}
+	_, err = t.ApplyTagOps([]TagOp{{Tag: tag, Remove: true}}, ThreadAll, &opts.TagOptions)
+	return err
+}
+
+// Muted returns true if a message of the thread carries the mute tag.
+func (t *Thread) Muted(opts *MuteOptions) bool {
+	if opts == nil {
+		opts = &MuteOptions{}
+	}
+	tag, _, _ := opts.ops()
+	var tg *Tag
+	tags := t.Tags()
+	for tags.Next(&tg) {
+		if tg.Value == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// MuteNew applies the mute operations of opts to the messages matching
+// query, typically "tag:new" after indexing, which belong to a thread
+// holding a muted message. It returns the number of messages whose tags
+// changed. opts may be nil.
+//
+// Threads are looked up by the thread IDs of the messages matching query,
+// so the cost is one query per thread with new mail.
+func (db *DB) MuteNew(query string, opts *MuteOptions) (int, error) {
+	if opts == nil {
+		opts = &MuteOptions{}
+	}
+	tag, ops, err := opts.ops()
+	if err != nil {
+		return 0, err
+	}
+	muteTerm := booleanTerm("tag", tag)
+
+	q := db.NewQuery(andQuery(query, "not "+muteTerm))
+	q.SetExcludeScheme(EXCLUDE_FALSE)
+	q.SetSortScheme(SORT_UNSORTED)
+	msgs, err := q.Messages()
+	if err != nil {
+		return 0, err
+	}
+	var threads []string
+	seen := map[string]bool{}
+	var msg *Message
+	for msgs.Next(&msg) {
+		if id := msg.ThreadID(); !seen[id] {
+			seen[id] = true
+			threads = append(threads, id)
+		}
+	}
+
+	var count int
+	var tagErr error
+	err = db.Atomic(func(db *DB) {
+		for _, id := range threads {
+			threadTerm := booleanTerm("thread", id)
+			muted := db.NewQuery(threadTerm + " and " + muteTerm)
+			muted.SetExcludeScheme(EXCLUDE_FALSE)
+			if muted.CountMessages() == 0 {
+				continue
+			}
+			n, err := db.applyTagOps(andQuery(query, threadTerm), ops, &opts.TagOptions, nil)
+			count += n
+			if err != nil {
+				tagErr = err
+				return
+			}
+		}
+	})
+	if tagErr != nil {
+		return count, tagErr
+	}
+	return count, err
+}
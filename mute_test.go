@@ -0,0 +1,59 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import "testing"
+
+func TestMute(t *testing.T) {
+	db, err := Open(dbPath, DBReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	qs := "subject:\"Introducing myself\""
+	thread, err := firstThread(db, qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &MuteOptions{Tag: "go-notmuch-muted", Ops: "+go-notmuch-muted"}
+	muted := booleanTerm("thread", thread.ID()) + " and tag:go-notmuch-muted"
+	if err := thread.Mute(opts); err != nil {
+		t.Fatalf("thread.Mute(): unexpected error: %s", err)
+	}
+	defer thread.Unmute(opts)
+	if want, got := thread.Count(), db.NewQuery(muted).CountMessages(); want != got {
+		t.Errorf("thread.Mute(): want %d muted messages got %d", want, got)
+	}
+	if thread, err = firstThread(db, qs); err != nil {
+		t.Fatal(err)
+	}
+	if !thread.Muted(opts) {
+		t.Errorf("thread.Muted(): want true got false")
+	}
+
+	// Pretend a new reply came in.
+	var msg *Message
+	if !thread.Messages().Next(&msg) {
+		t.Fatalf("thread.Messages(): want a message got none")
+	}
+	msg.RemoveTag("go-notmuch-muted")
+	msg.AddTag("go-notmuch-new")
+	defer msg.RemoveTag("go-notmuch-new")
+	count, err := db.MuteNew("tag:go-notmuch-new", opts)
+	if err != nil {
+		t.Fatalf("db.MuteNew(): unexpected error: %s", err)
+	}
+	if want, got := 1, count; want != got {
+		t.Errorf("db.MuteNew(): want %d messages changed got %d", want, got)
+	}
+
+	if err := thread.Unmute(opts); err != nil {
+		t.Fatalf("thread.Unmute(): unexpected error: %s", err)
+	}
+	if want, got := 0, db.NewQuery(muted).CountMessages(); want != got {
+		t.Errorf("thread.Unmute(): want %d muted messages got %d", want, got)
+	}
+}
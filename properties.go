@@ -0,0 +1,97 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// PropertyKey returns the key name in namespace, "namespace.name", following
+// the convention notmuch uses for its own properties, such as
+// "index.decryption". Applications should keep their properties under a
+// namespace of their own.
+func PropertyKey(namespace, name string) string {
+	return namespace + "." + name
+}
+
+// SetProperty replaces all the values of the property with key with value,
+// inside a single Freeze/Thaw.
+func (m *Message) SetProperty(key, value string) error {
+	return m.SetProperties(key, []string{value})
+}
+
+// SetProperties replaces all the values of the property with key with
+// values, inside a single Freeze/Thaw. An empty values removes the property.
+func (m *Message) SetProperties(key string, values []string) error {
+	var err error
+	atomicErr := m.Atomic(func(m *Message) {
+		if err = m.RemoveAllProperties(key); err != nil {
+			return
+		}
+		for _, value := range values {
+			if err = m.AddProperty(key, value); err != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return atomicErr
+}
+
+// PropertyInt returns the value of the property with key as an integer. It
+// returns ErrNotFound if the message has no value for key.
+func (m *Message) PropertyInt(key string) (int64, error) {
+	value, err := m.Property(key)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// SetPropertyInt replaces the values of the property with key with the
+// decimal representation of value.
+func (m *Message) SetPropertyInt(key string, value int64) error {
+	return m.SetProperty(key, strconv.FormatInt(value, 10))
+}
+
+// PropertyTime returns the value of the property with key as a time. It
+// returns ErrNotFound if the message has no value for key.
+func (m *Message) PropertyTime(key string) (time.Time, error) {
+	value, err := m.Property(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// SetPropertyTime replaces the values of the property with key with value,
+// formatted as RFC 3339 in UTC.
+func (m *Message) SetPropertyTime(key string, value time.Time) error {
+	return m.SetProperty(key, value.UTC().Format(time.RFC3339Nano))
+}
+
+// PropertyJSON decodes the JSON value of the property with key into v. It
+// returns ErrNotFound if the message has no value for key.
+func (m *Message) PropertyJSON(key string, v interface{}) error {
+	value, err := m.Property(key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(value), v)
+}
+
+// SetPropertyJSON replaces the values of the property with key with the JSON
+// encoding of v.
+func (m *Message) SetPropertyJSON(key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return m.SetProperty(key, string(value))
+}
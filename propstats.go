@@ -0,0 +1,107 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"sort"
+	"strings"
+)
+
+// PropertyKeyStat holds the use of a property key by the messages matching
+// a query.
+type PropertyKeyStat struct {
+	Key string
+
+	// Messages is the number of messages with at least one value for Key.
+	Messages int
+
+	// Values lists the distinct values of Key, sorted.
+	Values []*PropertyValueStat
+}
+
+// PropertyValueStat holds the number of messages with a property value.
+type PropertyValueStat struct {
+	Value    string
+	Messages int
+}
+
+// Query returns a query matching the messages with any value for the key,
+// the equivalent of "property:key=*", which notmuch does not support.
+func (s *PropertyKeyStat) Query() string {
+	values := make([]string, len(s.Values))
+	for i, v := range s.Values {
+		values[i] = v.Value
+	}
+	return PropertyAnyQuery(s.Key, values...)
+}
+
+// PropertyQuery returns the query term matching messages with the property
+// key=value, quoted if needed.
+func PropertyQuery(key, value string) string {
+	return booleanTerm("property", key+"="+value)
+}
+
+// PropertyAnyQuery returns a query matching messages with any of the given
+// values for the property key. It returns an empty string if there are no
+// values.
+func PropertyAnyQuery(key string, values ...string) string {
+	terms := make([]string, len(values))
+	for i, value := range values {
+		terms[i] = PropertyQuery(key, value)
+	}
+	if len(terms) <= 1 {
+		return strings.Join(terms, "")
+	}
+	return "( " + strings.Join(terms, " or ") + " )"
+}
+
+// PropertyStats returns, sorted by key, the property keys of the messages
+// matching query, with their values and the number of messages using them.
+// An empty query matches all messages.
+func (db *DB) PropertyStats(query string) ([]*PropertyKeyStat, error) {
+	q := db.NewQuery(query)
+	q.SetExcludeScheme(EXCLUDE_FALSE)
+	q.SetSortScheme(SORT_UNSORTED)
+	msgs, err := q.Messages()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*PropertyKeyStat{}
+	values := map[string]map[string]*PropertyValueStat{}
+	var msg *Message
+	for msgs.Next(&msg) {
+		seen := map[string]bool{}
+		props := msg.Properties("", false)
+		var prop *MessageProperty
+		for props.Next(&prop) {
+			stat := keys[prop.Key]
+			if stat == nil {
+				stat = &PropertyKeyStat{Key: prop.Key}
+				keys[prop.Key] = stat
+				values[prop.Key] = map[string]*PropertyValueStat{}
+			}
+			if !seen[prop.Key] {
+				seen[prop.Key] = true
+				stat.Messages++
+			}
+			vstat := values[prop.Key][prop.Value]
+			if vstat == nil {
+				vstat = &PropertyValueStat{Value: prop.Value}
+				values[prop.Key][prop.Value] = vstat
+				stat.Values = append(stat.Values, vstat)
+			}
+			vstat.Messages++
+		}
+	}
+
+	list := make([]*PropertyKeyStat, 0, len(keys))
+	for _, stat := range keys {
+		sort.Slice(stat.Values, func(i, j int) bool { return stat.Values[i].Value < stat.Values[j].Value })
+		list = append(list, stat)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list, nil
+}
@@ -0,0 +1,73 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPropertyQuery(t *testing.T) {
+	tests := []struct {
+		key, value, want string
+	}{
+		{"snooze", "2020-01-01", "property:snooze=2020-01-01"},
+		{"note", "to do", `property:"note=to do"`},
+		{"note", `say "hi"`, `property:"note=say ""hi"""`},
+		{"note", "", `property:note=`},
+	}
+	for _, tt := range tests {
+		if got := PropertyQuery(tt.key, tt.value); tt.want != got {
+			t.Errorf("PropertyQuery(%q, %q): want %q got %q", tt.key, tt.value, tt.want, got)
+		}
+	}
+	if want, got := "( property:a=1 or property:a=2 )", PropertyAnyQuery("a", "1", "2"); want != got {
+		t.Errorf("PropertyAnyQuery(): want %q got %q", want, got)
+	}
+	if want, got := "", PropertyAnyQuery("a"); want != got {
+		t.Errorf("PropertyAnyQuery(no values): want %q got %q", want, got)
+	}
+}
+
+func TestPropertyStats(t *testing.T) {
+	db, err := Open(dbPath, DBReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	qs := "subject:\"Introducing myself\""
+	msgs, err := db.NewQuery(qs).Messages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg *Message
+	if !msgs.Next(&msg) {
+		t.Fatalf("no message matching %s", qs)
+	}
+	key := PropertyKey("go-notmuch-test", "stats")
+	if err := msg.SetProperties(key, []string{"b", "a c"}); err != nil {
+		t.Fatal(err)
+	}
+	defer msg.RemoveAllProperties(key)
+
+	stats, err := db.PropertyStats(qs)
+	if err != nil {
+		t.Fatalf("db.PropertyStats(): unexpected error: %s", err)
+	}
+	var got *PropertyKeyStat
+	for _, stat := range stats {
+		if stat.Key == key {
+			got = stat
+		}
+	}
+	want := &PropertyKeyStat{Key: key, Messages: 1, Values: []*PropertyValueStat{{Value: "a c", Messages: 1}, {Value: "b", Messages: 1}}}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("db.PropertyStats(): want %+v got %+v", want, got)
+	}
+	if want, got := 1, db.NewQuery(got.Query()).CountMessages(); want != got {
+		t.Errorf("stat.Query(): want %d messages got %d", want, got)
+	}
+}
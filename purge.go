@@ -0,0 +1,154 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zenhack/go.notmuch/maildir"
+)
+
+// DefaultPurgeQuery selects the messages DB.Purge removes by default.
+const DefaultPurgeQuery = "tag:deleted"
+
+// PurgeOptions controls the behaviour of DB.Purge.
+type PurgeOptions struct {
+	// Trash, if set, is a maildir to move the files to instead of
+	// unlinking them, created if needed. A relative path is relative to
+	// the mail root; the folder should then be listed in new.ignore, or
+	// the messages will come back on the next `notmuch new`.
+	Trash string
+
+	// OlderThan, if set, only purges messages dated further back.
+	OlderThan time.Duration
+
+	// DryRun reports what would be purged without touching anything.
+	DryRun bool
+}
+
+// PurgeReport summarizes the outcome of DB.Purge.
+type PurgeReport struct {
+	// Messages is the number of messages purged.
+	Messages int
+
+	// Files lists the files removed from the mail store.
+	Files []string
+
+	// Bytes is the total size of Files.
+	Bytes int64
+}
+
+// Purge removes the files of every message matching query, DefaultPurgeQuery
+// if empty, from the disk and from the database. Each file is unlinked, or
+// moved to opts.Trash, before being removed from the database, so that a
+// crash midway never leaves a file which would be indexed again without its
+// tags. opts may be nil.
+func (db *DB) Purge(query string, opts *PurgeOptions) (*PurgeReport, error) {
+	if opts == nil {
+		opts = &PurgeOptions{}
+	}
+	if query == "" {
+		query = DefaultPurgeQuery
+	}
+	if opts.OlderThan > 0 {
+		query = andQuery(query, fmt.Sprintf("date:..@%d", time.Now().Add(-opts.OlderThan).Unix()))
+	}
+	var trash maildir.Dir
+	if opts.Trash != "" && !opts.DryRun {
+		dir := opts.Trash
+		if !filepath.IsAbs(dir) {
+			var err error
+			if dir, err = db.folderPath(dir); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		if trash, err = maildir.Create(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	// Collect the files first: the query results should not change under
+	// the iterator.
+	q := db.NewQuery(query)
+	q.SetExcludeScheme(EXCLUDE_FALSE)
+	q.SetSortScheme(SORT_UNSORTED)
+	msgs, err := q.Messages()
+	if err != nil {
+		return nil, err
+	}
+	var files [][]string
+	var msg *Message
+	for msgs.Next(&msg) {
+		files = append(files, msg.Filenames().slice())
+	}
+
+	report := &PurgeReport{}
+	if opts.DryRun {
+		for _, names := range files {
+			for _, name := range names {
+				if fi, err := os.Stat(name); err == nil {
+					report.Files = append(report.Files, name)
+					report.Bytes += fi.Size()
+				}
+			}
+			report.Messages++
+		}
+		return report, nil
+	}
+
+	var purgeErr error
+	err = db.Atomic(func(db *DB) {
+		for _, names := range files {
+			for _, name := range names {
+				fi, err := os.Stat(name)
+				if err == nil {
+					if purgeErr = purgeFile(name, trash); purgeErr != nil {
+						return
+					}
+					report.Files = append(report.Files, name)
+					report.Bytes += fi.Size()
+				} else if !os.IsNotExist(err) {
+					purgeErr = err
+					return
+				}
+				if err := db.RemoveMessage(name); err != nil && err != ErrDuplicateMessageID {
+					purgeErr = err
+					return
+				}
+			}
+			report.Messages++
+		}
+	})
+	if purgeErr != nil {
+		return report, purgeErr
+	}
+	return report, err
+}
+
+// purgeFile moves the file at path to trash, keeping its maildir flags, or
+// unlinks it if trash is empty. The file is copied, as trash may be on
+// another file system.
+func purgeFile(path string, trash maildir.Dir) error {
+	if trash != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		if _, info := maildir.SplitInfo(filepath.Base(path)); info != "" {
+			_, err = trash.DeliverFlags(f, maildir.ParseFlags(filepath.Base(path)))
+		} else {
+			_, err = trash.Deliver(f)
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,77 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zenhack/go.notmuch/maildir"
+)
+
+func TestPurge(t *testing.T) {
+	db, err := Open(dbPath, DBReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	folder := "go-notmuch-purge"
+	defer os.RemoveAll(filepath.Join(db.mailRoot(), folder))
+	res, err := db.Insert(strings.NewReader(testInsertMessage), folder, "+go-notmuch-deleted", &InsertOptions{CreateFolder: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.RemoveMessage(res.Path)
+
+	qs := "tag:go-notmuch-deleted"
+	// The test message is from 2006.
+	report, err := db.Purge(qs, &PurgeOptions{OlderThan: 100 * 365 * 24 * time.Hour, DryRun: true})
+	if err != nil || report.Messages != 0 {
+		t.Errorf("db.Purge(old messages): want nothing purged got %+v (error %v)", report, err)
+	}
+	report, err = db.Purge(qs, &PurgeOptions{OlderThan: time.Hour, DryRun: true})
+	if err != nil {
+		t.Fatalf("db.Purge(dry run): unexpected error: %s", err)
+	}
+	want := &PurgeReport{Messages: 1, Files: []string{res.Path}, Bytes: int64(len(testInsertMessage))}
+	if !reflect.DeepEqual(want, report) {
+		t.Errorf("db.Purge(dry run): want %+v got %+v", want, report)
+	}
+	if _, err := os.Stat(res.Path); err != nil {
+		t.Errorf("db.Purge(dry run): want %s kept got error %s", res.Path, err)
+	}
+
+	trash := filepath.Join(folder, "trash")
+	report, err = db.Purge(qs, &PurgeOptions{Trash: trash})
+	if err != nil {
+		t.Fatalf("db.Purge(): unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(want, report) {
+		t.Errorf("db.Purge(): want %+v got %+v", want, report)
+	}
+	if _, err := os.Stat(res.Path); !os.IsNotExist(err) {
+		t.Errorf("db.Purge(): want %s removed got error %v", res.Path, err)
+	}
+	if _, err := db.FindMessage("go-notmuch-insert@example.com"); err != ErrNotFound {
+		t.Errorf("db.FindMessage() after Purge: want error %q got %v", ErrNotFound, err)
+	}
+	md, err := maildir.Open(filepath.Join(db.mailRoot(), trash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := md.Messages()
+	if err != nil || len(files) != 1 {
+		t.Fatalf("trash: want 1 message got %v (error %v)", files, err)
+	}
+	if data, err := ioutil.ReadFile(files[0]); err != nil || string(data) != testInsertMessage {
+		t.Errorf("trash: want the purged message got %q (error %v)", data, err)
+	}
+}
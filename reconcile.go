@@ -0,0 +1,240 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/zenhack/go.notmuch/maildir"
+)
+
+// ReconcilePolicy decides which side wins when tags and maildir flags
+// disagree.
+type ReconcilePolicy int
+
+const (
+	// PreferTags rewrites the maildir flags to match the tags.
+	PreferTags ReconcilePolicy = iota
+
+	// PreferFlags changes the tags to match the maildir flags, then
+	// rewrites the flags of every filename to match.
+	PreferFlags
+
+	// PreferUnion sets every flag set on either side: a message is
+	// flagged if it has the flagged tag or a filename with the F flag, and
+	// read if it lacks the unread tag or has a filename with the S flag.
+	PreferUnion
+)
+
+// flagTags maps the maildir flags notmuch synchronizes to their tags. The
+// seen flag is the odd one: it stands for the absence of the unread tag.
+var flagTags = []struct {
+	flag byte
+	tag  string
+}{
+	{maildir.FlagDraft, "draft"},
+	{maildir.FlagFlagged, "flagged"},
+	{maildir.FlagPassed, "passed"},
+	{maildir.FlagReplied, "replied"},
+	{maildir.FlagSeen, "unread"},
+}
+
+// ReconcileOptions controls the behaviour of DB.ReconcileFlags.
+type ReconcileOptions struct {
+	Policy ReconcilePolicy
+
+	// DryRun reports the disagreements without fixing them.
+	DryRun bool
+}
+
+// FlagMismatch describes a message whose tags and maildir flags disagree.
+type FlagMismatch struct {
+	MessageID string
+
+	// TagFlags holds the synchronized flags implied by the tags of the
+	// message, e.g. "FS" for the flagged tag without the unread tag.
+	TagFlags string
+
+	// Filenames maps each maildir filename of the message to its
+	// synchronized flags.
+	Filenames map[string]string
+}
+
+// ReconcileReport summarizes the outcome of DB.ReconcileFlags.
+type ReconcileReport struct {
+	// Checked is the number of messages with maildir filenames checked.
+	Checked int
+
+	// Mismatches lists the messages whose tags and flags disagreed. Unless
+	// the run was a dry run, they have been fixed.
+	Mismatches []FlagMismatch
+}
+
+// ReconcileFlags compares the tags of every message matching query with the
+// maildir flags of its filenames, and fixes the messages where they
+// disagree according to opts.Policy, inside a single atomic section. Only
+// the flags notmuch synchronizes are considered: D, F, P, R and S. Filenames
+// outside of maildir new and cur directories are ignored. opts may be nil.
+//
+// It returns ErrFlagSyncDisabled, unless opts.DryRun is set, if
+// maildir.synchronize_flags is not enabled.
+func (db *DB) ReconcileFlags(query string, opts *ReconcileOptions) (*ReconcileReport, error) {
+	if opts == nil {
+		opts = &ReconcileOptions{}
+	}
+	if !opts.DryRun {
+		sync, err := db.configBool("maildir.synchronize_flags")
+		if err != nil {
+			return nil, err
+		}
+		if !sync {
+			return nil, ErrFlagSyncDisabled
+		}
+	}
+	q := db.NewQuery(query)
+	q.SetExcludeScheme(EXCLUDE_FALSE)
+	q.SetSortScheme(SORT_UNSORTED)
+	msgs, err := q.Messages()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconcileReport{}
+	var fixErr error
+	check := func(db *DB) {
+		var msg *Message
+		for msgs.Next(&msg) {
+			mismatch := checkFlags(msg)
+			if mismatch == nil {
+				continue
+			}
+			report.Checked++
+			if !mismatch.differs() {
+				continue
+			}
+			report.Mismatches = append(report.Mismatches, *mismatch)
+			if opts.DryRun {
+				continue
+			}
+			if fixErr = fixFlags(msg, mismatch, opts.Policy); fixErr != nil {
+				return
+			}
+		}
+	}
+	if opts.DryRun {
+		check(db)
+		return report, nil
+	}
+	err = db.Atomic(check)
+	if fixErr != nil {
+		return report, fixErr
+	}
+	return report, err
+}
+
+// checkFlags returns the flags of msg according to its tags and its maildir
+// filenames, or nil if it has no maildir filename.
+func checkFlags(msg *Message) *FlagMismatch {
+	var m *FlagMismatch
+	for _, name := range msg.Filenames().slice() {
+		if sub := filepath.Base(filepath.Dir(name)); sub != maildir.New && sub != maildir.Cur {
+			continue
+		}
+		if m == nil {
+			m = &FlagMismatch{MessageID: msg.ID(), Filenames: map[string]string{}}
+		}
+		m.Filenames[name] = syncedFlags(maildir.ParseFlags(filepath.Base(name)))
+	}
+	if m != nil {
+		m.TagFlags = tagFlags(msg.Tags().slice())
+	}
+	return m
+}
+
+// differs returns true if any filename has flags other than TagFlags.
+func (m *FlagMismatch) differs() bool {
+	for _, flags := range m.Filenames {
+		if flags != m.TagFlags {
+			return true
+		}
+	}
+	return false
+}
+
+// fileFlags returns the flags set on any of the filenames, the way notmuch
+// combines them.
+func (m *FlagMismatch) fileFlags() string {
+	var all string
+	for _, flags := range m.Filenames {
+		all += flags
+	}
+	return maildir.NormalizeFlags(all)
+}
+
+func fixFlags(msg *Message, m *FlagMismatch, policy ReconcilePolicy) error {
+	var flags string
+	switch policy {
+	case PreferFlags:
+		flags = m.fileFlags()
+	case PreferUnion:
+		flags = maildir.NormalizeFlags(m.TagFlags + m.fileFlags())
+	}
+	if policy != PreferTags {
+		tags := msg.Tags().slice()
+		for _, ft := range flagTags {
+			set := strings.IndexByte(flags, ft.flag) >= 0
+			if ft.flag == maildir.FlagSeen {
+				set = !set
+			}
+			tags = removeString(tags, ft.tag)
+			if set {
+				tags = append(tags, ft.tag)
+			}
+		}
+		if _, err := msg.SetTags(tags, nil); err != nil {
+			return err
+		}
+	}
+	return msg.TagsToMaildirFlags()
+}
+
+// tagFlags returns the synchronized flags implied by tags.
+func tagFlags(tags []string) string {
+	var flags []byte
+	for _, ft := range flagTags {
+		has := containsString(tags, ft.tag)
+		if ft.flag == maildir.FlagSeen {
+			has = !has
+		}
+		if has {
+			flags = append(flags, ft.flag)
+		}
+	}
+	return maildir.NormalizeFlags(string(flags))
+}
+
+// syncedFlags keeps only the flags notmuch synchronizes.
+func syncedFlags(flags string) string {
+	var kept []byte
+	for i := 0; i < len(flags); i++ {
+		for _, ft := range flagTags {
+			if flags[i] == ft.flag {
+				kept = append(kept, flags[i])
+			}
+		}
+	}
+	return string(kept)
+}
+
+func removeString(list []string, s string) []string {
+	kept := list[:0]
+	for _, v := range list {
+		if v != s {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
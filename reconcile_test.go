@@ -0,0 +1,100 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zenhack/go.notmuch/maildir"
+)
+
+func TestTagFlags(t *testing.T) {
+	tests := []struct {
+		tags  []string
+		flags string
+	}{
+		{[]string{"inbox", "unread"}, ""},
+		{[]string{"inbox"}, "S"},
+		{[]string{"replied", "flagged", "unread"}, "FR"},
+		{[]string{"draft", "passed"}, "DPS"},
+	}
+	for _, tt := range tests {
+		if got := tagFlags(tt.tags); tt.flags != got {
+			t.Errorf("tagFlags(%v): want %q got %q", tt.tags, tt.flags, got)
+		}
+	}
+	if want, got := "FS", syncedFlags("FST"); want != got {
+		t.Errorf("syncedFlags(): want %q got %q", want, got)
+	}
+}
+
+func TestReconcileFlags(t *testing.T) {
+	db, err := Open(dbPath, DBReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sync, err := db.GetConfig("maildir.synchronize_flags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.SetConfig("maildir.synchronize_flags", sync)
+	if err := db.SetConfig("maildir.synchronize_flags", "false"); err != nil {
+		t.Fatal(err)
+	}
+
+	md, err := maildir.Create(filepath.Join(db.mailRoot(), "go-notmuch-reconcile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(md.Path())
+	path, err := md.DeliverFlags(strings.NewReader(testInsertMessage), "F")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := db.AddMessage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { db.RemoveMessage(currentFilename(msg, path)) }()
+
+	qs := "id:" + msg.ID()
+	if _, err := db.ReconcileFlags(qs, nil); err != ErrFlagSyncDisabled {
+		t.Errorf("db.ReconcileFlags(): want error %q got %v", ErrFlagSyncDisabled, err)
+	}
+	report, err := db.ReconcileFlags(qs, &ReconcileOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("db.ReconcileFlags(dry run): unexpected error: %s", err)
+	}
+	want := &ReconcileReport{
+		Checked:    1,
+		Mismatches: []FlagMismatch{{MessageID: msg.ID(), TagFlags: "S", Filenames: map[string]string{path: "F"}}},
+	}
+	if !reflect.DeepEqual(want, report) {
+		t.Errorf("db.ReconcileFlags(dry run): want %+v got %+v", want, report)
+	}
+
+	if err := db.SetConfig("maildir.synchronize_flags", "true"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ReconcileFlags(qs, &ReconcileOptions{Policy: PreferUnion}); err != nil {
+		t.Fatalf("db.ReconcileFlags(union): unexpected error: %s", err)
+	}
+	if want, got := []string{"flagged"}, msg.Tags().slice(); !reflect.DeepEqual(want, got) {
+		t.Errorf("msg.Tags() after reconciling: want %v got %v", want, got)
+	}
+	if want, got := "FS", maildir.ParseFlags(currentFilename(msg, path)); want != got {
+		t.Errorf("flags after reconciling: want %q got %q", want, got)
+	}
+	report, err = db.ReconcileFlags(qs, &ReconcileOptions{DryRun: true})
+	if err != nil || len(report.Mismatches) != 0 {
+		t.Errorf("db.ReconcileFlags() again: want no mismatch got %+v (error %v)", report, err)
+	}
+}
@@ -0,0 +1,231 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// ReplyMode selects the recipients of a reply.
+type ReplyMode int
+
+const (
+	// ReplyToAll replies to the sender and all other recipients of the
+	// message, like `notmuch reply --reply-to=all`.
+	ReplyToAll ReplyMode = iota
+
+	// ReplyToSender replies to the sender of the message only, like
+	// `notmuch reply --reply-to=sender`.
+	ReplyToSender
+)
+
+// Reply holds the headers and a quoted body for a reply to a message.
+// Addresses are formatted as for a message header, e.g.
+// "Alice <alice@example.com>".
+type Reply struct {
+	From       string
+	To         []string
+	Cc         []string
+	Subject    string
+	InReplyTo  string
+	References string
+
+	// Body is the text of the message, quoted and preceded by an
+	// attribution line.
+	Body string
+}
+
+// identity is the user's name and addresses, as configured in user.name,
+// user.primary_email and user.other_email.
+type identity struct {
+	name      string
+	primary   string
+	addresses map[string]bool
+}
+
+func (id *identity) isOurs(addr string) bool {
+	return id.addresses[strings.ToLower(addr)]
+}
+
+func (id *identity) format(addr string) string {
+	return (&mail.Address{Name: id.name, Address: addr}).String()
+}
+
+// Reply builds a reply to the message, the way `notmuch reply` does:
+//
+// The From address is the first of the user's addresses (user.primary_email
+// and user.other_email) that the message was sent to, or the primary
+// address if there is none.
+//
+// The reply goes to the Reply-To address, or to the sender if there is no
+// Reply-To or it merely repeats one of the recipients, as mailing lists
+// which munge Reply-To do. ReplyToAll adds the other recipients, unless the
+// message has a Mail-Followup-To header, which is then used instead. The
+// user's own addresses are never included; replying to one's own message
+// goes to its original recipients.
+func (m *Message) Reply(mode ReplyMode) (*Reply, error) {
+	id, err := m.db().identity()
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := readMIMEFile(m.Filename())
+	if err != nil {
+		return nil, err
+	}
+	reply := buildReply(parsed.header, m.ID(), id, mode)
+	reply.Body = quoteMessage(parsed)
+	return reply, nil
+}
+
+func (db *DB) identity() (*identity, error) {
+	name, err := db.GetConfig("user.name")
+	if err != nil {
+		return nil, err
+	}
+	primary, err := db.GetConfig("user.primary_email")
+	if err != nil {
+		return nil, err
+	}
+	others, err := db.configValues("user.other_email")
+	if err != nil {
+		return nil, err
+	}
+	id := &identity{
+		name:      name,
+		primary:   strings.TrimSpace(primary),
+		addresses: map[string]bool{},
+	}
+	for _, addr := range append(others, id.primary) {
+		if addr != "" {
+			id.addresses[strings.ToLower(addr)] = true
+		}
+	}
+	return id, nil
+}
+
+// buildReply computes the headers of a reply to the message with the given
+// header and message ID.
+func buildReply(header textproto.MIMEHeader, messageID string, id *identity, mode ReplyMode) *Reply {
+	reply := &Reply{
+		From:      id.format(replyFrom(header, id)),
+		Subject:   decodeHeader(header.Get("Subject")),
+		InReplyTo: "<" + messageID + ">",
+	}
+	if !strings.HasPrefix(strings.ToLower(reply.Subject), "re:") {
+		reply.Subject = "Re: " + reply.Subject
+	}
+
+	references := strings.TrimSpace(header.Get("References"))
+	if references == "" {
+		references = strings.TrimSpace(header.Get("In-Reply-To"))
+	}
+	if references != "" {
+		references += " "
+	}
+	reply.References = references + reply.InReplyTo
+
+	seen := map[string]bool{}
+	add := func(list []string, value string) []string {
+		for _, addr := range parseAddresses(value) {
+			key := strings.ToLower(addr.Address)
+			if seen[key] || id.isOurs(addr.Address) {
+				continue
+			}
+			seen[key] = true
+			list = append(list, addr.String())
+		}
+		return list
+	}
+
+	if followup := header.Get("Mail-Followup-To"); mode == ReplyToAll && followup != "" {
+		reply.To = add(reply.To, followup)
+		return reply
+	}
+
+	sender := header.Get("Reply-To")
+	if sender == "" || replyToIsRedundant(header) {
+		sender = header.Get("From")
+	}
+	reply.To = add(reply.To, sender)
+	if mode == ReplyToAll || len(reply.To) == 0 {
+		reply.To = add(reply.To, header.Get("To"))
+	}
+	if mode == ReplyToAll {
+		reply.Cc = add(reply.Cc, header.Get("Cc"))
+	}
+	return reply
+}
+
+// replyFrom returns the user's address the message was sent to, looking at
+// the recipient headers and then at the headers MTAs add on delivery.
+func replyFrom(header textproto.MIMEHeader, id *identity) string {
+	for _, name := range []string{"To", "Cc", "Bcc", "Envelope-To", "X-Original-To", "Delivered-To"} {
+		for _, value := range header[textproto.CanonicalMIMEHeaderKey(name)] {
+			for _, addr := range parseAddresses(value) {
+				if id.isOurs(addr.Address) {
+					return addr.Address
+				}
+			}
+		}
+	}
+	return id.primary
+}
+
+// replyToIsRedundant reports whether the Reply-To header holds a single
+// address which also appears in To or Cc. Mailing lists which munge
+// Reply-To produce this, and replying to the sender should then ignore it.
+func replyToIsRedundant(header textproto.MIMEHeader) bool {
+	replyTo := parseAddresses(header.Get("Reply-To"))
+	if len(replyTo) != 1 {
+		return false
+	}
+	for _, name := range []string{"To", "Cc"} {
+		for _, addr := range parseAddresses(header.Get(name)) {
+			if strings.EqualFold(addr.Address, replyTo[0].Address) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseAddresses parses an address list header, returning nothing if it is
+// malformed.
+func parseAddresses(value string) []*mail.Address {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+	addrs, err := (&mail.AddressParser{WordDecoder: headerWordDecoder}).ParseList(value)
+	if err != nil {
+		return nil
+	}
+	return addrs
+}
+
+// quoteMessage returns the text of msg quoted for a reply, preceded by an
+// attribution line. Text parts are quoted; other parts are only mentioned.
+func quoteMessage(msg *mimeMessage) string {
+	var b strings.Builder
+	b.WriteString("On " + msg.header.Get("Date") + ", " + decodeHeader(msg.header.Get("From")) + " wrote:\n")
+	msg.root.walk(func(p *mimePart) {
+		switch {
+		case p.isMultipart() || p.message != nil:
+		case p.mediaType == "text/plain" && p.disposition() != "attachment":
+			text := strings.Replace(p.text(), "\r\n", "\n", -1)
+			for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+				if line == "" {
+					b.WriteString(">\n")
+				} else {
+					b.WriteString("> " + line + "\n")
+				}
+			}
+		default:
+			b.WriteString("> Non-text part: " + p.mediaType + "\n")
+		}
+	})
+	return b.String()
+}
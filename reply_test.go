@@ -0,0 +1,164 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testIdentity() *identity {
+	return &identity{
+		name:    "Me",
+		primary: "me@example.com",
+		addresses: map[string]bool{
+			"me@example.com":    true,
+			"me@work.example":   true,
+			"alias@example.com": true,
+		},
+	}
+}
+
+func TestBuildReply(t *testing.T) {
+	tests := []struct {
+		name   string
+		header map[string]string
+		mode   ReplyMode
+		want   Reply
+	}{
+		{
+			name: "reply all",
+			header: map[string]string{
+				"From": "Alice <alice@example.com>",
+				"To":   "me@work.example, Bob <bob@example.com>",
+				"Cc":   "carol@example.com, ME@example.com",
+			},
+			mode: ReplyToAll,
+			want: Reply{
+				From: "\"Me\" <me@work.example>",
+				To:   []string{"\"Alice\" <alice@example.com>", "\"Bob\" <bob@example.com>"},
+				Cc:   []string{"<carol@example.com>"},
+			},
+		},
+		{
+			name: "reply to sender",
+			header: map[string]string{
+				"From": "Alice <alice@example.com>",
+				"To":   "me@example.com, bob@example.com",
+			},
+			mode: ReplyToSender,
+			want: Reply{
+				From: "\"Me\" <me@example.com>",
+				To:   []string{"\"Alice\" <alice@example.com>"},
+			},
+		},
+		{
+			name: "reply-to",
+			header: map[string]string{
+				"From":     "alice@example.com",
+				"Reply-To": "alice@home.example",
+				"To":       "list@example.com",
+			},
+			mode: ReplyToSender,
+			want: Reply{
+				From: "\"Me\" <me@example.com>",
+				To:   []string{"<alice@home.example>"},
+			},
+		},
+		{
+			name: "munged reply-to",
+			header: map[string]string{
+				"From":     "alice@example.com",
+				"Reply-To": "list@example.com",
+				"To":       "list@example.com",
+			},
+			mode: ReplyToSender,
+			want: Reply{
+				From: "\"Me\" <me@example.com>",
+				To:   []string{"<alice@example.com>"},
+			},
+		},
+		{
+			name: "mail-followup-to",
+			header: map[string]string{
+				"From":             "alice@example.com",
+				"To":               "list@example.com",
+				"Cc":               "bob@example.com",
+				"Mail-Followup-To": "list@example.com, alias@example.com",
+			},
+			mode: ReplyToAll,
+			want: Reply{
+				From: "\"Me\" <me@example.com>",
+				To:   []string{"<list@example.com>"},
+			},
+		},
+		{
+			name: "own message",
+			header: map[string]string{
+				"From":         "Me <me@example.com>",
+				"To":           "bob@example.com",
+				"Delivered-To": "alias@example.com",
+			},
+			mode: ReplyToSender,
+			want: Reply{
+				From: "\"Me\" <alias@example.com>",
+				To:   []string{"<bob@example.com>"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		header := textproto.MIMEHeader{}
+		for k, v := range tt.header {
+			header.Set(k, v)
+		}
+		got := buildReply(header, "id@example.com", testIdentity(), tt.mode)
+		if tt.want.From != got.From || !reflect.DeepEqual(tt.want.To, got.To) || !reflect.DeepEqual(tt.want.Cc, got.Cc) {
+			t.Errorf("%s: buildReply(): want From %q To %q Cc %q got From %q To %q Cc %q",
+				tt.name, tt.want.From, tt.want.To, tt.want.Cc, got.From, got.To, got.Cc)
+		}
+	}
+}
+
+func TestBuildReplyThreading(t *testing.T) {
+	header := textproto.MIMEHeader{}
+	header.Set("Subject", "hello")
+	header.Set("In-Reply-To", "<parent@example.com>")
+	reply := buildReply(header, "id@example.com", testIdentity(), ReplyToAll)
+	if want, got := "Re: hello", reply.Subject; want != got {
+		t.Errorf("reply.Subject: want %q got %q", want, got)
+	}
+	if want, got := "<id@example.com>", reply.InReplyTo; want != got {
+		t.Errorf("reply.InReplyTo: want %q got %q", want, got)
+	}
+	if want, got := "<parent@example.com> <id@example.com>", reply.References; want != got {
+		t.Errorf("reply.References: want %q got %q", want, got)
+	}
+
+	header.Set("Subject", "RE: hello")
+	header.Set("References", "<root@example.com> <parent@example.com>")
+	reply = buildReply(header, "id@example.com", testIdentity(), ReplyToAll)
+	if want, got := "RE: hello", reply.Subject; want != got {
+		t.Errorf("reply.Subject: want %q got %q", want, got)
+	}
+	if want, got := "<root@example.com> <parent@example.com> <id@example.com>", reply.References; want != got {
+		t.Errorf("reply.References: want %q got %q", want, got)
+	}
+}
+
+func TestQuoteMessage(t *testing.T) {
+	msg, err := readMIMEMessage(strings.NewReader(testMultipartMessage), 0)
+	if err != nil {
+		t.Fatalf("readMIMEMessage(): unexpected error: %s", err)
+	}
+	want := "On Tue, 17 Nov 2009 18:21:38 -0500, Alice <alice@example.com> wrote:\n" +
+		"> café\n" +
+		"> Non-text part: text/html\n" +
+		"> Non-text part: application/octet-stream\n"
+	if got := quoteMessage(msg); want != got {
+		t.Errorf("quoteMessage(): want %q got %q", want, got)
+	}
+}
@@ -0,0 +1,214 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+)
+
+// Rule is a tagging rule: the tag operations to apply to messages matching
+// a query.
+type Rule struct {
+	// Name identifies the rule in reports. It is optional.
+	Name string `json:"name,omitempty"`
+
+	// Query selects the messages the rule applies to. It is combined with
+	// the base query passed to RuleSet.Apply.
+	Query string `json:"query"`
+
+	// Tags holds the tag operations to apply, in the syntax accepted by
+	// ParseTagOps, e.g. "+list -inbox".
+	Tags string `json:"tags"`
+
+	// Stop prevents later rules from being applied to messages matching
+	// this one.
+	Stop bool `json:"stop,omitempty"`
+}
+
+// RuleSet is an ordered list of tagging rules, typically applied to newly
+// indexed mail.
+//
+// Rule sets can be loaded from JSON files of the form
+//
+//	[
+//		{"name": "lists", "query": "to:list@example.com", "tags": "+list -inbox", "stop": true},
+//		{"query": "from:boss@example.com", "tags": "+important"}
+//	]
+type RuleSet struct {
+	Rules []Rule
+}
+
+// RuleMatch records a rule matching a message in a RuleReport.
+type RuleMatch struct {
+	Rule      *Rule
+	MessageID string
+
+	// Changed is true if applying the rule changed the tags of the
+	// message. On a dry run, it tells whether it would have.
+	Changed bool
+}
+
+// RuleReport lists, in order, which rules matched which messages.
+type RuleReport struct {
+	Matches []RuleMatch
+}
+
+// RuleOptions controls the behaviour of RuleSet.Apply.
+type RuleOptions struct {
+	TagOptions
+
+	// DryRun reports what the rules would do without changing any tags.
+	DryRun bool
+}
+
+// LoadRules reads a rule set from the JSON file at path.
+func LoadRules(path string) (*RuleSet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadRules(f)
+}
+
+// ReadRules reads a rule set in JSON from r. The tag operations of every
+// rule are checked with ParseTagOps.
+func ReadRules(r io.Reader) (*RuleSet, error) {
+	rs := &RuleSet{}
+	if err := json.NewDecoder(r).Decode(&rs.Rules); err != nil {
+		return nil, err
+	}
+	for _, rule := range rs.Rules {
+		if _, err := ParseTagOps(rule.Tags); err != nil {
+			return nil, err
+		}
+	}
+	return rs, nil
+}
+
+// Apply applies the rules, in order, to the messages matching base, such as
+// "tag:new". Each rule applies to the messages matching both base and its
+// own query, except those matched by an earlier rule with Stop set. All
+// changes are made inside a single atomic section. opts may be nil.
+//
+// The queries of all rules are evaluated before any tag is changed: a rule
+// removing a tag used in base or in a later rule's query does not change
+// what later rules match. A dry run thus reports exactly what a real run
+// would do.
+func (rs *RuleSet) Apply(db *DB, base string, opts *RuleOptions) (*RuleReport, error) {
+	if opts == nil {
+		opts = &RuleOptions{}
+	}
+	ruleOps := make([][]TagOp, len(rs.Rules))
+	for i, rule := range rs.Rules {
+		ops, err := ParseTagOps(rule.Tags)
+		if err == nil {
+			ops, err = opts.strictOps(ops)
+		}
+		if err != nil {
+			return nil, err
+		}
+		ruleOps[i] = ops
+	}
+
+	report := &RuleReport{}
+	var applyErr error
+	apply := func(db *DB) {
+		// Collect the matches first. A single Message is kept per ID, as
+		// one only sees its own tag changes; dry runs track the tags.
+		type match struct {
+			rule int
+			msg  *Message
+		}
+		var matches []match
+		byID := map[string]*Message{}
+		tags := map[string]map[string]bool{}
+		stopped := map[string]bool{}
+		for i := range rs.Rules {
+			q := db.NewQuery(andQuery(base, rs.Rules[i].Query))
+			q.SetExcludeScheme(EXCLUDE_FALSE)
+			msgs, err := q.Messages()
+			if err != nil {
+				applyErr = err
+				return
+			}
+			var msg *Message
+			for msgs.Next(&msg) {
+				id := msg.ID()
+				if stopped[id] {
+					continue
+				}
+				if byID[id] == nil {
+					byID[id] = msg
+					if opts.DryRun {
+						tags[id] = map[string]bool{}
+						for _, tag := range msg.Tags().slice() {
+							tags[id][tag] = true
+						}
+					}
+				}
+				matches = append(matches, match{rule: i, msg: byID[id]})
+				if rs.Rules[i].Stop {
+					stopped[id] = true
+				}
+			}
+		}
+
+		for _, m := range matches {
+			id := m.msg.ID()
+			var changed bool
+			if opts.DryRun {
+				changed = tagOpsChange(tags[id], ruleOps[m.rule])
+			} else {
+				var err error
+				if changed, err = m.msg.ApplyTagOps(ruleOps[m.rule], &opts.TagOptions); err != nil {
+					applyErr = err
+					return
+				}
+			}
+			report.Matches = append(report.Matches, RuleMatch{Rule: &rs.Rules[m.rule], MessageID: id, Changed: changed})
+		}
+	}
+
+	if opts.DryRun {
+		apply(db)
+		return report, applyErr
+	}
+	err := db.Atomic(apply)
+	if applyErr != nil {
+		return report, applyErr
+	}
+	return report, err
+}
+
+// andQuery returns a query matching the messages matching both a and b,
+// either of which may be empty or "*" to match everything.
+func andQuery(a, b string) string {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	switch {
+	case a == "" || a == "*":
+		return b
+	case b == "" || b == "*":
+		return a
+	default:
+		return "( " + a + " ) and ( " + b + " )"
+	}
+}
+
+// tagOpsChange applies ops to the set of tags of a message, and reports
+// whether they changed.
+func tagOpsChange(set map[string]bool, ops []TagOp) bool {
+	changed := false
+	for _, op := range ops {
+		if set[op.Tag] == op.Remove {
+			set[op.Tag] = !op.Remove
+			changed = true
+		}
+	}
+	return changed
+}
@@ -0,0 +1,147 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadRules(t *testing.T) {
+	rs, err := ReadRules(strings.NewReader(`[
+		{"name": "lists", "query": "to:list@example.com", "tags": "+list -inbox", "stop": true},
+		{"query": "from:boss@example.com", "tags": "+important"}
+	]`))
+	if err != nil {
+		t.Fatalf("ReadRules(): unexpected error: %s", err)
+	}
+	if want, got := 2, len(rs.Rules); want != got {
+		t.Fatalf("ReadRules(): want %d rules got %d", want, got)
+	}
+	if want, got := (Rule{Name: "lists", Query: "to:list@example.com", Tags: "+list -inbox", Stop: true}), rs.Rules[0]; want != got {
+		t.Errorf("ReadRules(): want first rule %+v got %+v", want, got)
+	}
+
+	if _, err := ReadRules(strings.NewReader(`[{"query": "*", "tags": "list"}]`)); err != ErrInvalidTagOp {
+		t.Errorf("ReadRules(bad tags): want error %q got %v", ErrInvalidTagOp, err)
+	}
+}
+
+func TestAndQuery(t *testing.T) {
+	tests := []struct {
+		a, b, want string
+	}{
+		{"", "from:alice", "from:alice"},
+		{"*", "from:alice", "from:alice"},
+		{"tag:new", "", "tag:new"},
+		{"tag:new", "from:alice or from:bob", "( tag:new ) and ( from:alice or from:bob )"},
+	}
+	for _, tt := range tests {
+		if got := andQuery(tt.a, tt.b); tt.want != got {
+			t.Errorf("andQuery(%q, %q): want %q got %q", tt.a, tt.b, tt.want, got)
+		}
+	}
+}
+
+func TestRuleSetApply(t *testing.T) {
+	db, err := Open(dbPath, DBReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	base := "subject:\"Introducing myself\""
+	total := db.NewQuery(base).CountMessages()
+	rs := &RuleSet{Rules: []Rule{
+		{Name: "first", Query: "id:20091118002059.067214ed@hikari", Tags: "+go-notmuch-first", Stop: true},
+		{Name: "all", Query: "*", Tags: "+go-notmuch-all"},
+	}}
+	defer db.Tag(base, "-go-notmuch-first -go-notmuch-all", nil)
+
+	report, err := rs.Apply(db, base, &RuleOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("rs.Apply(dry run): unexpected error: %s", err)
+	}
+	if want, got := total, len(report.Matches); want != got {
+		t.Errorf("rs.Apply(dry run): want %d matches got %d", want, got)
+	}
+	if n := db.NewQuery("tag:go-notmuch-first or tag:go-notmuch-all").CountMessages(); n != 0 {
+		t.Errorf("rs.Apply(dry run): want no messages tagged got %d", n)
+	}
+
+	report, err = rs.Apply(db, base, nil)
+	if err != nil {
+		t.Fatalf("rs.Apply(): unexpected error: %s", err)
+	}
+	if want, got := 1, db.NewQuery("tag:go-notmuch-first").CountMessages(); want != got {
+		t.Errorf("rs.Apply(): want %d messages tagged by the first rule got %d", want, got)
+	}
+	if want, got := total-1, db.NewQuery("tag:go-notmuch-all").CountMessages(); want != got {
+		t.Errorf("rs.Apply(): want %d messages tagged by the second rule got %d", want, got)
+	}
+	for _, m := range report.Matches {
+		if !m.Changed {
+			t.Errorf("rs.Apply(): want rule %q to change %q", m.Rule.Name, m.MessageID)
+		}
+	}
+}
+
+func TestRuleSetApplyDryRun(t *testing.T) {
+	db, err := Open(dbPath, DBReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Rules changing tags used by later rules, and undoing each other.
+	base := "subject:\"Introducing myself\" and tag:go-notmuch-new"
+	rs := &RuleSet{Rules: []Rule{
+		{Name: "read", Query: "*", Tags: "-go-notmuch-new +go-notmuch-seen"},
+		{Name: "later", Query: "tag:go-notmuch-new", Tags: "+go-notmuch-later"},
+		{Name: "unread", Query: "*", Tags: "-go-notmuch-seen"},
+	}}
+	if _, err := db.Tag("subject:\"Introducing myself\"", "+go-notmuch-new", nil); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Tag("subject:\"Introducing myself\"", "-go-notmuch-new -go-notmuch-seen -go-notmuch-later", nil)
+
+	dry, err := rs.Apply(db, base, &RuleOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("rs.Apply(dry run): unexpected error: %s", err)
+	}
+	report, err := rs.Apply(db, base, nil)
+	if err != nil {
+		t.Fatalf("rs.Apply(): unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(dry, report) {
+		t.Errorf("rs.Apply(): want the dry run report %+v got %+v", dry, report)
+	}
+	if len(report.Matches) == 0 {
+		t.Fatalf("rs.Apply(): want matches got none")
+	}
+	for _, m := range report.Matches {
+		if !m.Changed {
+			t.Errorf("rs.Apply(): want rule %q to change %q", m.Rule.Name, m.MessageID)
+		}
+	}
+	if n := db.NewQuery("subject:\"Introducing myself\" and (tag:go-notmuch-new or tag:go-notmuch-seen)").CountMessages(); n != 0 {
+		t.Errorf("rs.Apply(): want tags removed got %d messages still tagged", n)
+	}
+}
+
+func TestTagOpsChange(t *testing.T) {
+	set := map[string]bool{"inbox": true}
+	ops := []TagOp{{Tag: "inbox", Remove: true}, {Tag: "list"}}
+	if !tagOpsChange(set, ops) {
+		t.Errorf("tagOpsChange(%v): want a change got none", ops)
+	}
+	if want := map[string]bool{"inbox": false, "list": true}; !reflect.DeepEqual(want, set) {
+		t.Errorf("tagOpsChange(): want tags %v got %v", want, set)
+	}
+	if tagOpsChange(set, ops) {
+		t.Errorf("tagOpsChange(%v) again: want no change got one", ops)
+	}
+}
@@ -0,0 +1,650 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"io"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// SafeDB wraps a DB for use by several goroutines at once. libnotmuch
+// objects are not safe for concurrent use, so SafeDB runs every operation
+// on a dedicated goroutine, locked to its OS thread, one at a time.
+//
+// Methods returning notmuch objects, such as messages or iterators, return
+// snapshots instead: plain copies of their data, read in a single operation
+// and safe to use from any goroutine. Anything else can be done with Do.
+//
+// Some DB methods are left out on purpose. NewQuery and the iterators it
+// leads to would have to be used one call at a time, so Messages, Threads
+// and the Count methods replace them. Compact works on the path of a
+// database which is not open, so it needs no wrapper. A Journal holds its
+// DB: open it inside Do, and use it inside later calls of Do only.
+//
+// Objects derived from the database and left to the garbage collector are
+// destroyed on the worker goroutine too, after the operation running when
+// they are finalized.
+type SafeDB struct {
+	// db is only accessed from the worker goroutine.
+	db *DB
+
+	reqs     chan func()
+	done     chan struct{}
+	stopOnce sync.Once
+
+	// garbage holds the Close methods of the objects finalized, to be
+	// called by the worker goroutine.
+	garbageLock sync.Mutex
+	garbage     []func() error
+}
+
+// MessageSnapshot is a copy of the data of a message.
+type MessageSnapshot struct {
+	ID        string
+	ThreadID  string
+	Filenames []string
+	Date      time.Time
+	Tags      []string
+
+	From    string
+	To      string
+	Cc      string
+	Subject string
+
+	// Match and Excluded hold the message flags of the same name.
+	Match    bool
+	Excluded bool
+}
+
+// ThreadSnapshot is a copy of the data of a thread and of its messages.
+type ThreadSnapshot struct {
+	ID      string
+	Subject string
+	Total   int
+	Matched int
+
+	// MatchedAuthors and Authors are those returned by Thread.Authors.
+	MatchedAuthors []string
+	Authors        []string
+
+	Oldest time.Time
+	Newest time.Time
+	Tags   []string
+
+	// Messages holds all the messages of the thread, oldest first.
+	Messages []*MessageSnapshot
+}
+
+// snapshotMessage copies the data of m, closing the objects it creates
+// rather than leaving them to the garbage collector.
+func snapshotMessage(m *Message) *MessageSnapshot {
+	tags := m.Tags()
+	defer tags.Close()
+	return &MessageSnapshot{
+		ID:        m.ID(),
+		ThreadID:  m.ThreadID(),
+		Filenames: m.Filenames().slice(),
+		Date:      m.Date(),
+		Tags:      tags.slice(),
+		From:      m.Header("From"),
+		To:        m.Header("To"),
+		Cc:        m.Header("Cc"),
+		Subject:   m.Header("Subject"),
+		Match:     m.Flag(MessageFlagMatch),
+		Excluded:  m.Flag(MessageFlagExcluded),
+	}
+}
+
+// snapshotThread copies the data of t and of its messages, like
+// snapshotMessage.
+func snapshotThread(t *Thread) *ThreadSnapshot {
+	tags := t.Tags()
+	defer tags.Close()
+	matched, unmatched := t.Authors()
+	ts := &ThreadSnapshot{
+		ID:             t.ID(),
+		Subject:        t.Subject(),
+		Total:          t.Count(),
+		Matched:        t.CountMatched(),
+		MatchedAuthors: matched,
+		Authors:        unmatched,
+		Oldest:         t.OldestDate(),
+		Newest:         t.NewestDate(),
+		Tags:           tags.slice(),
+	}
+	msgs := t.Messages()
+	defer msgs.Close()
+	var msg *Message
+	for msgs.Next(&msg) {
+		// The messages belong to the thread, and go with it.
+		ts.Messages = append(ts.Messages, snapshotMessage(msg))
+	}
+	return ts
+}
+
+// InsertSnapshot is an InsertResult with a snapshot of the message.
+type InsertSnapshot struct {
+	Message   *MessageSnapshot
+	Path      string
+	Duplicate bool
+}
+
+// NewSafeDB wraps db, which must not be used directly anymore.
+func NewSafeDB(db *DB) *SafeDB {
+	s := newSafeDB()
+	s.exec(func() { s.attach(db) })
+	return s
+}
+
+// OpenSafe opens the database at path, like Open, from the goroutine
+// which will run all its operations. Caller is responsible for closing the
+// database when done.
+func OpenSafe(path string, mode DBMode) (*SafeDB, error) {
+	s := newSafeDB()
+	var err error
+	s.exec(func() {
+		var db *DB
+		if db, err = Open(path, mode); err == nil {
+			s.attach(db)
+		}
+	})
+	if err != nil {
+		s.stop()
+		return nil, err
+	}
+	return s, nil
+}
+
+// attach makes db the database of s, routing the finalizers of the objects
+// derived from it to the worker goroutine.
+func (s *SafeDB) attach(db *DB) {
+	c := (*cStruct)(db)
+	c.lock.Lock()
+	c.gcClose = s.queueGarbage
+	c.lock.Unlock()
+	s.db = db
+}
+
+// queueGarbage is called by finalizers with the Close method of the object
+// finalized.
+func (s *SafeDB) queueGarbage(close func() error) {
+	s.garbageLock.Lock()
+	s.garbage = append(s.garbage, close)
+	s.garbageLock.Unlock()
+}
+
+// collect closes the objects queued by queueGarbage. It must only be
+// called by the worker goroutine.
+func (s *SafeDB) collect() {
+	s.garbageLock.Lock()
+	garbage := s.garbage
+	s.garbage = nil
+	s.garbageLock.Unlock()
+	for _, close := range garbage {
+		close()
+	}
+}
+
+func newSafeDB() *SafeDB {
+	s := &SafeDB{
+		reqs: make(chan func()),
+		done: make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *SafeDB) run() {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	for {
+		select {
+		case req := <-s.reqs:
+			req()
+			s.collect()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *SafeDB) stop() {
+	s.stopOnce.Do(func() { close(s.done) })
+}
+
+// exec runs f on the worker goroutine and waits for it to return. A panic
+// in f is raised again in the calling goroutine. It returns ErrDBClosed if
+// the worker is stopped.
+func (s *SafeDB) exec(f func()) error {
+	var panicked interface{}
+	finished := make(chan struct{})
+	req := func() {
+		defer close(finished)
+		defer func() { panicked = recover() }()
+		f()
+	}
+	select {
+	case s.reqs <- req:
+	case <-s.done:
+		return ErrDBClosed
+	}
+	<-finished
+	if panicked != nil {
+		panic(panicked)
+	}
+	return nil
+}
+
+// Do calls f with the database on the goroutine running all operations,
+// and returns its error. Neither the database nor any object obtained from
+// it may be kept once f returns. It returns ErrDBClosed once the database
+// is closed.
+func (s *SafeDB) Do(f func(*DB) error) error {
+	var err error
+	if execErr := s.exec(func() {
+		if s.db == nil {
+			err = ErrDBClosed
+			return
+		}
+		err = f(s.db)
+	}); execErr != nil {
+		return execErr
+	}
+	return err
+}
+
+// Close closes the database and stops the goroutine running its
+// operations.
+func (s *SafeDB) Close() error {
+	err := s.Do(func(db *DB) error {
+		s.db = nil
+		return db.Close()
+	})
+	s.stop()
+	if err == ErrDBClosed {
+		return nil
+	}
+	return err
+}
+
+// Version is DB.Version.
+func (s *SafeDB) Version() (version int, err error) {
+	err = s.Do(func(db *DB) error {
+		version = db.Version()
+		return nil
+	})
+	return
+}
+
+// Revision is DB.Revision.
+func (s *SafeDB) Revision() (rev uint64, uuid string, err error) {
+	err = s.Do(func(db *DB) error {
+		rev, uuid = db.Revision()
+		return nil
+	})
+	return
+}
+
+// Path is DB.Path.
+func (s *SafeDB) Path() (path string, err error) {
+	err = s.Do(func(db *DB) error {
+		path = db.Path()
+		return nil
+	})
+	return
+}
+
+// LastStatus is DB.LastStatus.
+func (s *SafeDB) LastStatus() (status string, err error) {
+	err = s.Do(func(db *DB) error {
+		status = db.LastStatus()
+		return nil
+	})
+	return
+}
+
+// NeedsUpgrade is DB.NeedsUpgrade.
+func (s *SafeDB) NeedsUpgrade() (needs bool, err error) {
+	err = s.Do(func(db *DB) error {
+		needs = db.NeedsUpgrade()
+		return nil
+	})
+	return
+}
+
+// Upgrade is DB.Upgrade.
+func (s *SafeDB) Upgrade() error {
+	return s.Do(func(db *DB) error { return db.Upgrade() })
+}
+
+// Atomic is DB.Atomic, run as a single operation. As with Do, neither the
+// database nor any object obtained from it may be kept once callback
+// returns.
+func (s *SafeDB) Atomic(callback func(*DB)) error {
+	return s.Do(func(db *DB) error { return db.Atomic(callback) })
+}
+
+// AddMessage is DB.AddMessage, returning a snapshot of the message.
+func (s *SafeDB) AddMessage(filename string) (snap *MessageSnapshot, err error) {
+	err = s.Do(func(db *DB) error {
+		msg, err := db.AddMessage(filename)
+		if msg != nil {
+			snap = snapshotMessage(msg)
+			msg.Close()
+		}
+		return err
+	})
+	return
+}
+
+// RemoveMessage is DB.RemoveMessage.
+func (s *SafeDB) RemoveMessage(filename string) error {
+	return s.Do(func(db *DB) error { return db.RemoveMessage(filename) })
+}
+
+// FindMessage is DB.FindMessage, returning a snapshot of the message.
+func (s *SafeDB) FindMessage(id string) (snap *MessageSnapshot, err error) {
+	err = s.Do(func(db *DB) error {
+		msg, err := db.FindMessage(id)
+		if err != nil {
+			return err
+		}
+		defer msg.Close()
+		snap = snapshotMessage(msg)
+		return nil
+	})
+	return
+}
+
+// FindMessageByFilename is DB.FindMessageByFilename, returning a snapshot
+// of the message.
+func (s *SafeDB) FindMessageByFilename(filename string) (snap *MessageSnapshot, err error) {
+	err = s.Do(func(db *DB) error {
+		msg, err := db.FindMessageByFilename(filename)
+		if err != nil {
+			return err
+		}
+		defer msg.Close()
+		snap = snapshotMessage(msg)
+		return nil
+	})
+	return
+}
+
+// FindMessages is DB.FindMessages, returning snapshots of the messages
+// found.
+func (s *SafeDB) FindMessages(ids []string) (snaps []*MessageSnapshot, missing []string, err error) {
+	err = s.Do(func(db *DB) error {
+		msgs, notFound, err := db.FindMessages(ids)
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			snaps = append(snaps, snapshotMessage(msg))
+			msg.Close()
+		}
+		missing = notFound
+		return nil
+	})
+	return
+}
+
+// Insert is DB.Insert, returning a snapshot of the message.
+func (s *SafeDB) Insert(r io.Reader, folder string, ops string, opts *InsertOptions) (snap *InsertSnapshot, err error) {
+	err = s.Do(func(db *DB) error {
+		res, err := db.Insert(r, folder, ops, opts)
+		if err != nil {
+			return err
+		}
+		defer res.Message.Close()
+		snap = &InsertSnapshot{
+			Message:   snapshotMessage(res.Message),
+			Path:      res.Path,
+			Duplicate: res.Duplicate,
+		}
+		return nil
+	})
+	return
+}
+
+// Messages returns snapshots of the messages matching query, in sort
+// order, all read in a single operation.
+func (s *SafeDB) Messages(query string, sort SortMode) (snaps []*MessageSnapshot, err error) {
+	err = s.Do(func(db *DB) error {
+		q := db.NewQuery(query)
+		defer q.Close()
+		q.SetSortScheme(sort)
+		msgs, err := q.Messages()
+		if err != nil {
+			return err
+		}
+		var msg *Message
+		for msgs.Next(&msg) {
+			snaps = append(snaps, snapshotMessage(msg))
+			msg.Close()
+		}
+		return nil
+	})
+	return
+}
+
+// Threads returns snapshots of the threads matching query, in sort order,
+// all read in a single operation.
+func (s *SafeDB) Threads(query string, sort SortMode) (snaps []*ThreadSnapshot, err error) {
+	err = s.Do(func(db *DB) error {
+		q := db.NewQuery(query)
+		defer q.Close()
+		q.SetSortScheme(sort)
+		threads, err := q.Threads()
+		if err != nil {
+			return err
+		}
+		var thread *Thread
+		for threads.Next(&thread) {
+			snaps = append(snaps, snapshotThread(thread))
+			thread.Close()
+		}
+		return nil
+	})
+	return
+}
+
+// CountMessages returns the number of messages matching query.
+func (s *SafeDB) CountMessages(query string) (count int, err error) {
+	err = s.Do(func(db *DB) error {
+		q := db.NewQuery(query)
+		defer q.Close()
+		count = q.CountMessages()
+		return nil
+	})
+	return
+}
+
+// CountThreads returns the number of threads matching query.
+func (s *SafeDB) CountThreads(query string) (count int, err error) {
+	err = s.Do(func(db *DB) error {
+		q := db.NewQuery(query)
+		defer q.Close()
+		count = q.CountThreads()
+		return nil
+	})
+	return
+}
+
+// Tags is DB.Tags, returning the tag names.
+func (s *SafeDB) Tags() (tags []string, err error) {
+	err = s.Do(func(db *DB) error {
+		all, err := db.Tags()
+		if err != nil {
+			return err
+		}
+		defer all.Close()
+		tags = all.slice()
+		return nil
+	})
+	return
+}
+
+// GetConfig is DB.GetConfig.
+func (s *SafeDB) GetConfig(key string) (value string, err error) {
+	err = s.Do(func(db *DB) (err error) {
+		value, err = db.GetConfig(key)
+		return
+	})
+	return
+}
+
+// GetConfigList returns the config keys starting with prefix and their
+// values, as listed by DB.GetConfigList.
+func (s *SafeDB) GetConfigList(prefix string) (config map[string]string, err error) {
+	err = s.Do(func(db *DB) error {
+		cl, err := db.GetConfigList(prefix)
+		if err != nil {
+			return err
+		}
+		defer cl.Close()
+		config = map[string]string{}
+		var key, value string
+		for cl.Next(&key, &value) {
+			config[key] = value
+		}
+		return nil
+	})
+	return
+}
+
+// SetConfig is DB.SetConfig.
+func (s *SafeDB) SetConfig(key, value string) error {
+	return s.Do(func(db *DB) error { return db.SetConfig(key, value) })
+}
+
+// Tag is DB.Tag.
+func (s *SafeDB) Tag(query string, ops string, opts *TagOptions) (count int, err error) {
+	err = s.Do(func(db *DB) (err error) {
+		count, err = db.Tag(query, ops, opts)
+		return
+	})
+	return
+}
+
+// TagStats is DB.TagStats.
+func (s *SafeDB) TagStats(baseQuery string) (stats []*TagStat, err error) {
+	err = s.Do(func(db *DB) (err error) {
+		stats, err = db.TagStats(baseQuery)
+		return
+	})
+	return
+}
+
+// Dump is DB.Dump. w is written to from the goroutine running the
+// database operations.
+func (s *SafeDB) Dump(w io.Writer, query string, opts *DumpOptions) error {
+	return s.Do(func(db *DB) error { return db.Dump(w, query, opts) })
+}
+
+// Restore is DB.Restore. r is read from the goroutine running the database
+// operations.
+func (s *SafeDB) Restore(r io.Reader, opts *RestoreOptions) (report *RestoreReport, err error) {
+	err = s.Do(func(db *DB) (err error) {
+		report, err = db.Restore(r, opts)
+		return
+	})
+	return
+}
+
+// PropertyStats is DB.PropertyStats.
+func (s *SafeDB) PropertyStats(query string) (stats []*PropertyKeyStat, err error) {
+	err = s.Do(func(db *DB) (err error) {
+		stats, err = db.PropertyStats(query)
+		return
+	})
+	return
+}
+
+// MissingMessages is DB.MissingMessages.
+func (s *SafeDB) MissingMessages(query string) (missing []*MissingMessage, err error) {
+	err = s.Do(func(db *DB) (err error) {
+		missing, err = db.MissingMessages(query)
+		return
+	})
+	return
+}
+
+// MuteNew is DB.MuteNew.
+func (s *SafeDB) MuteNew(query string, opts *MuteOptions) (count int, err error) {
+	err = s.Do(func(db *DB) (err error) {
+		count, err = db.MuteNew(query, opts)
+		return
+	})
+	return
+}
+
+// Move is DB.Move.
+func (s *SafeDB) Move(query string, folder string, opts *MoveOptions) (count int, err error) {
+	err = s.Do(func(db *DB) (err error) {
+		count, err = db.Move(query, folder, opts)
+		return
+	})
+	return
+}
+
+// ReconcileFlags is DB.ReconcileFlags.
+func (s *SafeDB) ReconcileFlags(query string, opts *ReconcileOptions) (report *ReconcileReport, err error) {
+	err = s.Do(func(db *DB) (err error) {
+		report, err = db.ReconcileFlags(query, opts)
+		return
+	})
+	return
+}
+
+// Duplicates is DB.Duplicates.
+func (s *SafeDB) Duplicates(query string) (sets []*DuplicateSet, err error) {
+	err = s.Do(func(db *DB) (err error) {
+		sets, err = db.Duplicates(query)
+		return
+	})
+	return
+}
+
+// Dedupe is DB.Dedupe.
+func (s *SafeDB) Dedupe(query string, opts *DedupeOptions) (report *DedupeReport, err error) {
+	err = s.Do(func(db *DB) (err error) {
+		report, err = db.Dedupe(query, opts)
+		return
+	})
+	return
+}
+
+// Purge is DB.Purge.
+func (s *SafeDB) Purge(query string, opts *PurgeOptions) (report *PurgeReport, err error) {
+	err = s.Do(func(db *DB) (err error) {
+		report, err = db.Purge(query, opts)
+		return
+	})
+	return
+}
+
+// ImportMbox is DB.ImportMbox. The whole import is a single operation:
+// other calls wait for it to finish. opts.Progress is called from the
+// goroutine running the database operations, and must not use s.
+func (s *SafeDB) ImportMbox(r io.Reader, folder string, opts *ImportOptions) (report *ImportReport, err error) {
+	err = s.Do(func(db *DB) (err error) {
+		report, err = db.ImportMbox(r, folder, opts)
+		return
+	})
+	return
+}
+
+// ApplyRules is RuleSet.Apply on the database. The rules are all applied in
+// a single operation.
+func (s *SafeDB) ApplyRules(rs *RuleSet, base string, opts *RuleOptions) (report *RuleReport, err error) {
+	err = s.Do(func(db *DB) (err error) {
+		report, err = rs.Apply(db, base, opts)
+		return
+	})
+	return
+}
@@ -0,0 +1,246 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// These tests are meant to be run with -race as well.
+
+func TestSafeDBSerializes(t *testing.T) {
+	s := newSafeDB()
+
+	// count is not synchronized: the race detector complains unless all
+	// the increments run on the worker, one at a time.
+	count := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if err := s.exec(func() { count++ }); err != nil {
+					t.Errorf("s.exec(): unexpected error: %s", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	var got int
+	s.exec(func() { got = count })
+	if want := 20 * 50; want != got {
+		t.Errorf("s.exec(): want %d increments got %d", want, got)
+	}
+
+	s.stop()
+	if err := s.exec(func() { count++ }); err != ErrDBClosed {
+		t.Errorf("s.exec() after stop: want error %v got %v", ErrDBClosed, err)
+	}
+}
+
+func TestSafeDBPanic(t *testing.T) {
+	s := newSafeDB()
+	defer s.stop()
+
+	func() {
+		defer func() {
+			if want, got := "go-notmuch", recover(); want != got {
+				t.Errorf("s.exec(): want panic %q got %v", want, got)
+			}
+		}()
+		s.exec(func() { panic("go-notmuch") })
+	}()
+	// The worker survives the panic.
+	ran := false
+	if err := s.exec(func() { ran = true }); err != nil || !ran {
+		t.Errorf("s.exec() after panic: want run got %v (error %v)", ran, err)
+	}
+}
+
+func TestSafeDBGarbage(t *testing.T) {
+	s := newSafeDB()
+	defer s.stop()
+
+	// Finalizers queue Close methods from their own goroutine.
+	closed := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.queueGarbage(func() error {
+				closed++
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	// The garbage is collected after the next operation, so check from the
+	// one after it.
+	s.exec(func() {})
+	var got int
+	s.exec(func() { got = closed })
+	if want := 10; want != got {
+		t.Errorf("s.collect(): want %d objects closed got %d", want, got)
+	}
+}
+
+func TestSafeDBFinalizers(t *testing.T) {
+	defer debug.SetGCPercent(debug.SetGCPercent(1))
+
+	s, err := OpenSafe(dbPath, DBReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Leave lots of objects to the garbage collector while other goroutines
+	// use the database: their finalizers must not destroy them concurrently.
+	qs := "subject:\"Introducing myself\""
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				err := s.Do(func(db *DB) error {
+					q := db.NewQuery(qs)
+					msgs, err := q.Messages()
+					if err != nil {
+						return err
+					}
+					var msg *Message
+					for msgs.Next(&msg) {
+						msg.Tags().slice()
+					}
+					return nil
+				})
+				if err != nil {
+					t.Errorf("s.Do(): unexpected error: %s", err)
+					return
+				}
+				runtime.GC()
+			}
+		}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if _, err := s.Threads(qs, SORT_OLDEST_FIRST); err != nil {
+					t.Errorf("s.Threads(): unexpected error: %s", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSafeDB(t *testing.T) {
+	s, err := OpenSafe(dbPath, DBReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	qs := "subject:\"Introducing myself\""
+	want, err := s.Messages(qs, SORT_OLDEST_FIRST)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) == 0 {
+		t.Fatalf("s.Messages(%q): want messages got none", qs)
+	}
+	var root string
+	s.Do(func(db *DB) error {
+		root = db.mailRoot()
+		return nil
+	})
+	folder := "go-notmuch-safedb"
+	defer os.RemoveAll(filepath.Join(root, folder))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		res, err := s.Insert(strings.NewReader(testInsertMessage), folder, "+go-notmuch-test", &InsertOptions{CreateFolder: true})
+		if err != nil {
+			t.Errorf("s.Insert(): unexpected error: %s", err)
+			return
+		}
+		defer s.RemoveMessage(res.Path)
+		if want, got := "go-notmuch-insert@example.com", res.Message.ID; want != got {
+			t.Errorf("s.Insert(): want message %q got %q", want, got)
+		}
+		msg, err := s.FindMessageByFilename(res.Path)
+		if err != nil {
+			t.Errorf("s.FindMessageByFilename(): unexpected error: %s", err)
+		} else if msg.ID != res.Message.ID {
+			t.Errorf("s.FindMessageByFilename(): want message %q got %q", res.Message.ID, msg.ID)
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := s.Messages(qs, SORT_OLDEST_FIRST)
+			if err != nil {
+				t.Errorf("s.Messages(): unexpected error: %s", err)
+			} else if !reflect.DeepEqual(want, got) {
+				t.Errorf("s.Messages(): want %+v got %+v", want, got)
+			}
+			threads, err := s.Threads(qs, SORT_OLDEST_FIRST)
+			if err != nil {
+				t.Errorf("s.Threads(): unexpected error: %s", err)
+			} else if len(threads) != 1 || threads[0].ID != want[0].ThreadID {
+				t.Errorf("s.Threads(): want thread %s got %+v", want[0].ThreadID, threads)
+			}
+			msg, err := s.FindMessage(want[0].ID)
+			if err != nil {
+				t.Errorf("s.FindMessage(): unexpected error: %s", err)
+			} else if msg.Subject != want[0].Subject {
+				t.Errorf("s.FindMessage(): want subject %q got %q", want[0].Subject, msg.Subject)
+			}
+			found, missing, err := s.FindMessages([]string{want[0].ID, "notfound@example.com"})
+			if err != nil {
+				t.Errorf("s.FindMessages(): unexpected error: %s", err)
+			} else if len(found) != 1 || found[0].ID != want[0].ID || !reflect.DeepEqual([]string{"notfound@example.com"}, missing) {
+				t.Errorf("s.FindMessages(): want %s found and notfound@example.com missing got %+v and %v", want[0].ID, found, missing)
+			}
+			if _, err := s.GetConfigList("maildir."); err != nil {
+				t.Errorf("s.GetConfigList(): unexpected error: %s", err)
+			}
+			if _, err := s.NeedsUpgrade(); err != nil {
+				t.Errorf("s.NeedsUpgrade(): unexpected error: %s", err)
+			}
+			if _, err := s.LastStatus(); err != nil {
+				t.Errorf("s.LastStatus(): unexpected error: %s", err)
+			}
+			var count int
+			if err := s.Atomic(func(db *DB) { count = db.NewQuery(qs).CountMessages() }); err != nil {
+				t.Errorf("s.Atomic(): unexpected error: %s", err)
+			} else if count != len(want) {
+				t.Errorf("s.Atomic(): want %d messages counted got %d", len(want), count)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("s.Close(): unexpected error: %s", err)
+	}
+	if _, err := s.CountMessages(qs); err != ErrDBClosed {
+		t.Errorf("s.CountMessages() after Close: want error %v got %v", ErrDBClosed, err)
+	}
+}
@@ -0,0 +1,312 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"strings"
+)
+
+// TagOp is a single tag operation: adding or removing a tag.
+type TagOp struct {
+	Tag    string
+	Remove bool
+}
+
+// String returns the operation in notmuch's syntax, e.g. "+inbox".
+func (op TagOp) String() string {
+	if op.Remove {
+		return "-" + op.Tag
+	}
+	return "+" + op.Tag
+}
+
+// TagOptions controls the behaviour of the tagging helpers.
+type TagOptions struct {
+	// SyncMaildirFlags calls Message.TagsToMaildirFlags on each message
+	// whose tags were changed.
+	SyncMaildirFlags bool
+
+	// Strict, if set, normalizes every tag added with the policy and
+	// rejects tags which do not follow it. Tags to remove are not checked.
+	Strict *TagPolicy
+}
+
+// ThreadScope selects the messages of a thread a tag operation applies to.
+type ThreadScope int
+
+const (
+	// ThreadAll applies to all the messages of the thread.
+	ThreadAll ThreadScope = iota
+
+	// ThreadMatched applies to the messages of the thread which matched
+	// the query the thread was found with.
+	ThreadMatched
+)
+
+// TagChange describes how the tags of a message were changed.
+type TagChange struct {
+	Added   []string
+	Removed []string
+}
+
+// Changed returns true if any tag was added or removed.
+func (c *TagChange) Changed() bool {
+	return len(c.Added) > 0 || len(c.Removed) > 0
+}
+
+// ParseTagOps parses tag operations in the syntax of `notmuch tag`: a
+// whitespace separated list of tags, each prefixed with '+' to add it or '-'
+// to remove it, e.g. "+inbox -unread". It returns ErrInvalidTagOp for
+// anything else, and ErrTagTooLong for tags longer than TagMax.
+func ParseTagOps(ops string) ([]TagOp, error) {
+	var parsed []TagOp
+	for _, field := range strings.Fields(ops) {
+		if len(field) < 2 || (field[0] != '+' && field[0] != '-') {
+			return nil, ErrInvalidTagOp
+		}
+		op := TagOp{Tag: field[1:], Remove: field[0] == '-'}
+		if len(op.Tag) > TagMax {
+			return nil, ErrTagTooLong
+		}
+		parsed = append(parsed, op)
+	}
+	return parsed, nil
+}
+
+// ApplyTagOps applies ops to the message in order, inside a single
+// Freeze/Thaw, skipping the ones which would not change anything. It
+// returns true if the tags of the message changed. opts may be nil.
+func (m *Message) ApplyTagOps(ops []TagOp, opts *TagOptions) (bool, error) {
+	if opts == nil {
+		opts = &TagOptions{}
+	}
+	ops, err := opts.strictOps(ops)
+	if err != nil {
+		return false, err
+	}
+	tags := map[string]bool{}
+	for _, tag := range m.Tags().slice() {
+		tags[tag] = true
+	}
+
+	var changed bool
+	atomicErr := m.Atomic(func(m *Message) {
+		for _, op := range ops {
+			if tags[op.Tag] != op.Remove {
+				continue
+			}
+			if op.Remove {
+				err = m.RemoveTag(op.Tag)
+			} else {
+				err = m.AddTag(op.Tag)
+			}
+			if err != nil {
+				return
+			}
+			tags[op.Tag] = !op.Remove
+			changed = true
+		}
+	})
+	if err != nil {
+		return changed, err
+	}
+	if atomicErr != nil {
+		return changed, atomicErr
+	}
+	if changed && opts.SyncMaildirFlags {
+		return changed, m.TagsToMaildirFlags()
+	}
+	return changed, nil
+}
+
+// AddTag adds a tag to the messages of the thread in scope, and returns the
+// IDs of the messages which did not already have it.
+func (t *Thread) AddTag(tag string, scope ThreadScope) ([]string, error) {
+	return t.ApplyTagOps([]TagOp{{Tag: tag}}, scope, nil)
+}
+
+// RemoveTag removes a tag from the messages of the thread in scope, and
+// returns the IDs of the messages which had it.
+func (t *Thread) RemoveTag(tag string, scope ThreadScope) ([]string, error) {
+	return t.ApplyTagOps([]TagOp{{Tag: tag, Remove: true}}, scope, nil)
+}
+
+// ApplyTagOps applies ops to the messages of the thread in scope, inside a
+// single atomic section, and returns the IDs of the messages whose tags
+// changed. opts may be nil.
+func (t *Thread) ApplyTagOps(ops []TagOp, scope ThreadScope, opts *TagOptions) ([]string, error) {
+	ops, err := opts.strictOps(ops)
+	if err != nil {
+		return nil, err
+	}
+	var changed []string
+	var tagErr error
+	err = t.db().Atomic(func(db *DB) {
+		msgs := t.Messages()
+		var msg *Message
+		for msgs.Next(&msg) {
+			if scope == ThreadMatched && !msg.Flag(MessageFlagMatch) {
+				continue
+			}
+			ok, err := msg.ApplyTagOps(ops, opts)
+			if ok {
+				changed = append(changed, msg.ID())
+			}
+			if err != nil {
+				tagErr = err
+				return
+			}
+		}
+	})
+	if tagErr != nil {
+		return changed, tagErr
+	}
+	return changed, err
+}
+
+// SetTags makes tags the exact set of tags of the message. Unlike removing
+// all tags and adding them back, it only adds and removes the tags which
+// differ, inside a single Freeze/Thaw, so a message already carrying tags is
+// left untouched. It returns ErrTagTooLong, without changing anything, if a
+// tag is longer than TagMax, and reports the tags added and removed. opts may
+// be nil.
+func (m *Message) SetTags(tags []string, opts *TagOptions) (*TagChange, error) {
+	if opts == nil {
+		opts = &TagOptions{}
+	}
+	tags, err := opts.strictTags(tags)
+	if err != nil {
+		return &TagChange{}, err
+	}
+	want := map[string]bool{}
+	for _, tag := range tags {
+		if tag == "" {
+			return &TagChange{}, ErrInvalidTagOp
+		}
+		if len(tag) > TagMax {
+			return &TagChange{}, ErrTagTooLong
+		}
+		want[tag] = true
+	}
+	change := &TagChange{}
+	current := m.Tags().slice()
+	for _, tag := range current {
+		if !want[tag] {
+			change.Removed = append(change.Removed, tag)
+		}
+	}
+	for _, tag := range tags {
+		if want[tag] && !containsString(current, tag) {
+			change.Added = append(change.Added, tag)
+			// Skip duplicates in tags.
+			want[tag] = false
+		}
+	}
+	if !change.Changed() {
+		return change, nil
+	}
+
+	atomicErr := m.Atomic(func(m *Message) {
+		for _, tag := range change.Removed {
+			if err = m.RemoveTag(tag); err != nil {
+				return
+			}
+		}
+		for _, tag := range change.Added {
+			if err = m.AddTag(tag); err != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		return change, err
+	}
+	if atomicErr != nil {
+		return change, atomicErr
+	}
+	if opts.SyncMaildirFlags {
+		return change, m.TagsToMaildirFlags()
+	}
+	return change, nil
+}
+
+// Tag applies the tag operations in ops, in the syntax accepted by
+// ParseTagOps, to every message matching query, and returns the number of
+// messages whose tags changed. opts may be nil.
+//
+// Like `notmuch tag`, it narrows the query to the messages the operations
+// would actually change, and applies all changes inside a single atomic
+// section. Note that notmuch cannot roll the section back: if an error
+// occurs midway, the changes made up to that point are kept.
+func (db *DB) Tag(query string, ops string, opts *TagOptions) (int, error) {
+	parsed, err := ParseTagOps(ops)
+	if err != nil {
+		return 0, err
+	}
+	return db.applyTagOps(query, parsed, opts, nil)
+}
+
+// applyTagOps is DB.Tag with parsed operations. If onChange is set, it is
+// called for every message whose tags changed, with its tags before the
+// change, sorted.
+func (db *DB) applyTagOps(query string, ops []TagOp, opts *TagOptions, onChange func(m *Message, before []string)) (int, error) {
+	ops, err := opts.strictOps(ops)
+	if err != nil || len(ops) == 0 {
+		return 0, err
+	}
+	q := db.NewQuery(tagOpsQuery(query, ops))
+	q.SetExcludeScheme(EXCLUDE_FALSE)
+	q.SetSortScheme(SORT_UNSORTED)
+	msgs, err := q.Messages()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	var tagErr error
+	err = db.Atomic(func(db *DB) {
+		var msg *Message
+		for msgs.Next(&msg) {
+			var before []string
+			if onChange != nil {
+				before = sortedTags(msg)
+			}
+			changed, err := msg.ApplyTagOps(ops, opts)
+			if changed {
+				count++
+				if onChange != nil {
+					onChange(msg, before)
+				}
+			}
+			if err != nil {
+				tagErr = err
+				return
+			}
+		}
+	})
+	if tagErr != nil {
+		return count, tagErr
+	}
+	return count, err
+}
+
+// tagOpsQuery restricts query to the messages which ops would change, the
+// same way notmuch's _optimize_tag_query does: a message needs changing if
+// it lacks a tag to be added or has a tag to be removed.
+func tagOpsQuery(query string, ops []TagOp) string {
+	terms := make([]string, 0, len(ops))
+	for _, op := range ops {
+		term := booleanTerm("tag", op.Tag)
+		if !op.Remove {
+			term = "not " + term
+		}
+		terms = append(terms, term)
+	}
+	narrow := "( " + strings.Join(terms, " or ") + " )"
+	if q := strings.TrimSpace(query); q != "" && q != "*" {
+		return "( " + q + " ) and " + narrow
+	}
+	return narrow
+}
@@ -0,0 +1,157 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTagOps(t *testing.T) {
+	ops, err := ParseTagOps("  +inbox\t-unread +a+b ")
+	if err != nil {
+		t.Fatalf("ParseTagOps(): unexpected error: %s", err)
+	}
+	want := []TagOp{{Tag: "inbox"}, {Tag: "unread", Remove: true}, {Tag: "a+b"}}
+	if !reflect.DeepEqual(want, ops) {
+		t.Errorf("ParseTagOps(): want %v got %v", want, ops)
+	}
+
+	for _, bad := range []string{"inbox", "+inbox -", "+inbox unread"} {
+		if _, err := ParseTagOps(bad); err != ErrInvalidTagOp {
+			t.Errorf("ParseTagOps(%q): want error %q got %v", bad, ErrInvalidTagOp, err)
+		}
+	}
+	if _, err := ParseTagOps("+" + strings.Repeat("x", TagMax+1)); err != ErrTagTooLong {
+		t.Errorf("ParseTagOps(long tag): want error %q got %v", ErrTagTooLong, err)
+	}
+}
+
+func TestTagOpsQuery(t *testing.T) {
+	ops := []TagOp{{Tag: "inbox"}, {Tag: "to do", Remove: true}}
+	tests := []struct {
+		query, want string
+	}{
+		{"", `( not tag:inbox or tag:"to do" )`},
+		{"*", `( not tag:inbox or tag:"to do" )`},
+		{"from:alice", `( from:alice ) and ( not tag:inbox or tag:"to do" )`},
+	}
+	for _, tt := range tests {
+		if got := tagOpsQuery(tt.query, ops); tt.want != got {
+			t.Errorf("tagOpsQuery(%q): want %q got %q", tt.query, tt.want, got)
+		}
+	}
+}
+
+func TestDBTag(t *testing.T) {
+	db, err := Open(dbPath, DBReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	qs := "subject:\"Introducing myself\""
+	total := db.NewQuery(qs).CountMessages()
+	count, err := db.Tag(qs, "+go-notmuch-test -unread", nil)
+	if err != nil {
+		t.Fatalf("db.Tag(): unexpected error: %s", err)
+	}
+	defer db.Tag(qs, "-go-notmuch-test +unread", nil)
+	if want, got := total, count; want != got {
+		t.Errorf("db.Tag(): want %d messages changed got %d", want, got)
+	}
+	if want, got := total, db.NewQuery(qs+" and tag:go-notmuch-test and not tag:unread").CountMessages(); want != got {
+		t.Errorf("db.Tag(): want %d messages tagged got %d", want, got)
+	}
+
+	count, err = db.Tag(qs, "+go-notmuch-test", nil)
+	if err != nil {
+		t.Fatalf("db.Tag(): unexpected error: %s", err)
+	}
+	if want, got := 0, count; want != got {
+		t.Errorf("db.Tag() again: want %d messages changed got %d", want, got)
+	}
+}
+
+func TestSetTags(t *testing.T) {
+	db, err := Open(dbPath, DBReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	msg, err := db.FindMessage("1258471718-6781-2-git-send-email-dottedmag@dottedmag.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	change, err := msg.SetTags([]string{"inbox", "go-notmuch-test", "go-notmuch-test"}, nil)
+	if err != nil {
+		t.Fatalf("msg.SetTags(): unexpected error: %s", err)
+	}
+	defer msg.SetTags([]string{"inbox", "unread"}, nil)
+	if want, got := (&TagChange{Added: []string{"go-notmuch-test"}, Removed: []string{"unread"}}), change; !reflect.DeepEqual(want, got) {
+		t.Errorf("msg.SetTags(): want %+v got %+v", want, got)
+	}
+	if want, got := []string{"go-notmuch-test", "inbox"}, msg.Tags().slice(); !reflect.DeepEqual(want, got) {
+		t.Errorf("msg.Tags() after SetTags: want %v got %v", want, got)
+	}
+
+	change, err = msg.SetTags([]string{"go-notmuch-test", "inbox"}, nil)
+	if err != nil {
+		t.Fatalf("msg.SetTags() again: unexpected error: %s", err)
+	}
+	if change.Changed() {
+		t.Errorf("msg.SetTags() again: want no change got %+v", change)
+	}
+
+	if _, err := msg.SetTags([]string{strings.Repeat("x", TagMax+1)}, nil); err != ErrTagTooLong {
+		t.Errorf("msg.SetTags(long tag): want error %q got %v", ErrTagTooLong, err)
+	}
+	if want, got := []string{"go-notmuch-test", "inbox"}, msg.Tags().slice(); !reflect.DeepEqual(want, got) {
+		t.Errorf("msg.Tags() after failed SetTags: want %v got %v", want, got)
+	}
+}
+
+func TestThreadTag(t *testing.T) {
+	db, err := Open(dbPath, DBReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	thread, err := firstThread(db, "subject:\"Introducing myself\"")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, err := thread.AddTag("go-notmuch-test", ThreadMatched)
+	if err != nil {
+		t.Fatalf("thread.AddTag(matched): unexpected error: %s", err)
+	}
+	defer thread.RemoveTag("go-notmuch-test", ThreadAll)
+	if want, got := thread.CountMatched(), len(changed); want != got {
+		t.Errorf("thread.AddTag(matched): want %d messages changed got %d", want, got)
+	}
+
+	changed, err = thread.AddTag("go-notmuch-test", ThreadAll)
+	if err != nil {
+		t.Fatalf("thread.AddTag(all): unexpected error: %s", err)
+	}
+	if want, got := thread.Count()-thread.CountMatched(), len(changed); want != got {
+		t.Errorf("thread.AddTag(all): want %d messages changed got %d", want, got)
+	}
+	qs := booleanTerm("thread", thread.ID()) + " and tag:go-notmuch-test"
+	if want, got := thread.Count(), db.NewQuery(qs).CountMessages(); want != got {
+		t.Errorf("thread.AddTag(all): want %d messages tagged got %d", want, got)
+	}
+
+	changed, err = thread.RemoveTag("go-notmuch-test", ThreadAll)
+	if err != nil {
+		t.Fatalf("thread.RemoveTag(): unexpected error: %s", err)
+	}
+	if want, got := thread.Count(), len(changed); want != got {
+		t.Errorf("thread.RemoveTag(): want %d messages changed got %d", want, got)
+	}
+}
@@ -0,0 +1,149 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TagPolicy holds rules tags must follow, on top of those checked by
+// ValidateTag.
+type TagPolicy struct {
+	// Lowercase rejects tags with upper case letters. Normalize folds them
+	// to lower case instead.
+	Lowercase bool
+
+	// ASCII rejects tags with characters outside of ASCII.
+	ASCII bool
+
+	// Allowed, if not empty, restricts tags to letters, digits and the
+	// characters it holds.
+	Allowed string
+
+	// MaxLength is the maximum length of tags in bytes. Zero, or anything
+	// larger than TagMax, means TagMax.
+	MaxLength int
+}
+
+// DefaultTagPolicy accepts lower case tags made of ASCII letters, digits
+// and the punctuation characters left alone by the hex encoding of dumps, so
+// that they never need quoting in queries or escaping in dumps.
+var DefaultTagPolicy = TagPolicy{
+	Lowercase: true,
+	ASCII:     true,
+	Allowed:   "-_.@=,+",
+}
+
+// ValidateTag checks the rules every tag should follow: it must not be empty
+// or longer than TagMax, start with '+' or '-', or hold whitespace, control
+// characters or invalid UTF-8. It returns ErrTagEmpty, ErrTagTooLong,
+// ErrTagLeadingSign or ErrTagInvalidChar accordingly.
+func ValidateTag(tag string) error {
+	if tag == "" {
+		return ErrTagEmpty
+	}
+	if len(tag) > TagMax {
+		return ErrTagTooLong
+	}
+	if tag[0] == '+' || tag[0] == '-' {
+		return ErrTagLeadingSign
+	}
+	if !utf8.ValidString(tag) {
+		return ErrTagInvalidChar
+	}
+	for _, r := range tag {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return ErrTagInvalidChar
+		}
+	}
+	return nil
+}
+
+// Validate checks tag against ValidateTag and the policy. Besides the errors
+// of ValidateTag, it returns ErrTagCase for upper case letters when
+// p.Lowercase is set, and ErrTagInvalidChar for characters the policy does
+// not allow.
+func (p *TagPolicy) Validate(tag string) error {
+	if err := ValidateTag(tag); err != nil {
+		return err
+	}
+	if p.MaxLength > 0 && len(tag) > p.MaxLength {
+		return ErrTagTooLong
+	}
+	for _, r := range tag {
+		if p.Lowercase && unicode.IsUpper(r) {
+			return ErrTagCase
+		}
+		if p.ASCII && r >= utf8.RuneSelf {
+			return ErrTagInvalidChar
+		}
+		if p.Allowed != "" && !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(p.Allowed, r) {
+			return ErrTagInvalidChar
+		}
+	}
+	return nil
+}
+
+// Normalize trims surrounding whitespace from tag, folds it to lower case if
+// p.Lowercase is set, and validates the result.
+func (p *TagPolicy) Normalize(tag string) (string, error) {
+	tag = strings.TrimSpace(tag)
+	if p.Lowercase {
+		tag = strings.ToLower(tag)
+	}
+	return tag, p.Validate(tag)
+}
+
+// TagNeedsQuoting returns true if tag must be quoted in a "tag:" query term,
+// as it holds whitespace, control characters, '"', ')' or non-ASCII
+// characters.
+func TagNeedsQuoting(tag string) bool {
+	return termNeedsQuoting(tag)
+}
+
+// TagQuery returns the query term matching messages tagged with tag, quoted
+// if needed.
+func TagQuery(tag string) string {
+	return booleanTerm("tag", tag)
+}
+
+// strictOps normalizes the tags added by ops with opts.Strict, if set. Tags
+// to remove are left alone, so that tags predating the policy can still be
+// removed.
+func (opts *TagOptions) strictOps(ops []TagOp) ([]TagOp, error) {
+	if opts == nil || opts.Strict == nil {
+		return ops, nil
+	}
+	normalized := make([]TagOp, len(ops))
+	for i, op := range ops {
+		if !op.Remove {
+			tag, err := opts.Strict.Normalize(op.Tag)
+			if err != nil {
+				return nil, err
+			}
+			op.Tag = tag
+		}
+		normalized[i] = op
+	}
+	return normalized, nil
+}
+
+// strictTags normalizes tags with opts.Strict, if set.
+func (opts *TagOptions) strictTags(tags []string) ([]string, error) {
+	if opts == nil || opts.Strict == nil {
+		return tags, nil
+	}
+	normalized := make([]string, len(tags))
+	for i, tag := range tags {
+		tag, err := opts.Strict.Normalize(tag)
+		if err != nil {
+			return nil, err
+		}
+		normalized[i] = tag
+	}
+	return normalized, nil
+}
@@ -0,0 +1,96 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want error
+	}{
+		{"inbox", nil},
+		{"To Do", ErrTagInvalidChar},
+		{"café", nil},
+		{"", ErrTagEmpty},
+		{"-inbox", ErrTagLeadingSign},
+		{"+inbox", ErrTagLeadingSign},
+		{"a\x01b", ErrTagInvalidChar},
+		{"a\xffb", ErrTagInvalidChar},
+		{strings.Repeat("x", TagMax+1), ErrTagTooLong},
+	}
+	for _, tt := range tests {
+		if got := ValidateTag(tt.tag); tt.want != got {
+			t.Errorf("ValidateTag(%q): want %v got %v", tt.tag, tt.want, got)
+		}
+	}
+}
+
+func TestTagPolicy(t *testing.T) {
+	p := DefaultTagPolicy
+	p.MaxLength = 8
+	tests := []struct {
+		tag, normalized string
+		validate, err   error
+	}{
+		{"lists.go", "lists.go", nil, nil},
+		{"lists/go", "lists/go", ErrTagInvalidChar, ErrTagInvalidChar},
+		{"café", "café", ErrTagInvalidChar, ErrTagInvalidChar},
+		{" Inbox ", "inbox", ErrTagInvalidChar, nil},
+		{"Inbox", "inbox", ErrTagCase, nil},
+		{"a#b", "a#b", ErrTagInvalidChar, ErrTagInvalidChar},
+		{"toolongtag", "toolongtag", ErrTagTooLong, ErrTagTooLong},
+	}
+	for _, tt := range tests {
+		if got := p.Validate(tt.tag); tt.validate != got {
+			t.Errorf("p.Validate(%q): want %v got %v", tt.tag, tt.validate, got)
+		}
+		got, err := p.Normalize(tt.tag)
+		if tt.normalized != got || tt.err != err {
+			t.Errorf("p.Normalize(%q): want %q, %v got %q, %v", tt.tag, tt.normalized, tt.err, got, err)
+		}
+		// What the default policy accepts is safe in queries and dumps.
+		if err == nil && (TagNeedsQuoting(got) || hexEncode(got) != got) {
+			t.Errorf("p.Normalize(%q): %q needs quoting or escaping", tt.tag, got)
+		}
+	}
+}
+
+func TestTagQuery(t *testing.T) {
+	tests := []struct {
+		tag, want string
+	}{
+		{"inbox", "tag:inbox"},
+		{"to do", `tag:"to do"`},
+		{`say "hi"`, `tag:"say ""hi"""`},
+		{"café", `tag:"café"`},
+	}
+	for _, tt := range tests {
+		if got := TagQuery(tt.tag); tt.want != got {
+			t.Errorf("TagQuery(%q): want %q got %q", tt.tag, tt.want, got)
+		}
+		if want, got := tt.want != "tag:"+tt.tag, TagNeedsQuoting(tt.tag); want != got {
+			t.Errorf("TagNeedsQuoting(%q): want %v got %v", tt.tag, want, got)
+		}
+	}
+}
+
+func TestStrictOps(t *testing.T) {
+	opts := &TagOptions{Strict: &DefaultTagPolicy}
+	ops, err := opts.strictOps([]TagOp{{Tag: "Inbox"}, {Tag: "Old Tag", Remove: true}})
+	if err != nil {
+		t.Fatalf("opts.strictOps(): unexpected error: %s", err)
+	}
+	if want := []TagOp{{Tag: "inbox"}, {Tag: "Old Tag", Remove: true}}; !reflect.DeepEqual(want, ops) {
+		t.Errorf("opts.strictOps(): want %v got %v", want, ops)
+	}
+	if _, err := opts.strictOps([]TagOp{{Tag: "new tag"}}); err != ErrTagInvalidChar {
+		t.Errorf("opts.strictOps(invalid tag): want error %q got %v", ErrTagInvalidChar, err)
+	}
+}
@@ -0,0 +1,104 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import "sort"
+
+// TagStat holds the counts of messages and threads carrying a tag.
+type TagStat struct {
+	Tag string
+
+	// Total is the number of messages with the tag, Unread the number of
+	// those also tagged unread.
+	Total  int
+	Unread int
+
+	// Threads is the number of threads holding a message with the tag.
+	Threads int
+}
+
+// TagStats returns, sorted by tag, the counts for every tag carried by
+// messages matching baseQuery. An empty baseQuery matches all messages.
+//
+// The counts are those of the queries "baseQuery and tag:<tag>", with the
+// tags listed in search.exclude_tags excluded: messages with an excluded
+// tag are only counted for that tag, unless baseQuery mentions it. They are
+// all collected in a single pass over the messages matching baseQuery.
+func (db *DB) TagStats(baseQuery string) ([]*TagStat, error) {
+	excludes, err := db.configValues("search.exclude_tags")
+	if err != nil {
+		return nil, err
+	}
+	q := db.NewQuery(baseQuery)
+	q.SetExcludeScheme(EXCLUDE_FALSE)
+	q.SetSortScheme(SORT_UNSORTED)
+	excluded := map[string]bool{}
+	for _, tag := range excludes {
+		switch err := q.AddTagExclude(tag); err {
+		case nil:
+			excluded[tag] = true
+		case ErrIgnored:
+			// The base query mentions the tag, so it is not excluded.
+		default:
+			return nil, err
+		}
+	}
+	msgs, err := q.Messages()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := map[string]*TagStat{}
+	threads := map[string]map[string]bool{}
+	var msg *Message
+	for msgs.Next(&msg) {
+		tags := msg.Tags().slice()
+		// A message with an excluded tag only matches queries mentioning
+		// that tag, hence only counts for it; with two, it matches none.
+		var only string
+		skip := false
+		for _, tag := range tags {
+			if !excluded[tag] {
+				continue
+			}
+			if only != "" {
+				skip = true
+				break
+			}
+			only = tag
+		}
+		if skip {
+			continue
+		}
+		unread := containsString(tags, "unread")
+		thread := msg.ThreadID()
+		for _, tag := range tags {
+			if only != "" && tag != only {
+				continue
+			}
+			stat := stats[tag]
+			if stat == nil {
+				stat = &TagStat{Tag: tag}
+				stats[tag] = stat
+				threads[tag] = map[string]bool{}
+			}
+			stat.Total++
+			if unread {
+				stat.Unread++
+			}
+			if !threads[tag][thread] {
+				threads[tag][thread] = true
+				stat.Threads++
+			}
+		}
+	}
+
+	list := make([]*TagStat, 0, len(stats))
+	for _, stat := range stats {
+		list = append(list, stat)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Tag < list[j].Tag })
+	return list, nil
+}
@@ -0,0 +1,53 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import "testing"
+
+func TestTagStats(t *testing.T) {
+	db, err := Open(dbPath, DBReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	excludes, err := db.configValues("search.exclude_tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := "from:notmuchmail.org or from:dottedmag.net"
+	stats, err := db.TagStats(base)
+	if err != nil {
+		t.Fatalf("db.TagStats(): unexpected error: %s", err)
+	}
+	if len(stats) == 0 {
+		t.Fatalf("db.TagStats(): want some tags got none")
+	}
+	count := func(qs string, threads bool) int {
+		q := db.NewQuery(andQuery(base, qs))
+		for _, tag := range excludes {
+			q.AddTagExclude(tag)
+		}
+		if threads {
+			return q.CountThreads()
+		}
+		return q.CountMessages()
+	}
+	for i, stat := range stats {
+		if i > 0 && stats[i-1].Tag >= stat.Tag {
+			t.Errorf("db.TagStats(): tags not sorted: %q before %q", stats[i-1].Tag, stat.Tag)
+		}
+		term := TagQuery(stat.Tag)
+		want := TagStat{
+			Tag:     stat.Tag,
+			Total:   count(term, false),
+			Unread:  count(term+" and tag:unread", false),
+			Threads: count(term, true),
+		}
+		if want != *stat {
+			t.Errorf("db.TagStats(): want %+v got %+v", want, *stat)
+		}
+	}
+}
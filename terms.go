@@ -0,0 +1,31 @@
+package notmuch
+
+// Copyright © 2015 The go.notmuch Authors. Authors can be found in the AUTHORS file.
+// Licensed under the GPLv3 or later.
+// See COPYING at the root of the repository for details.
+
+import "strings"
+
+// booleanTerm renders prefix:term for use in a query string, quoting term
+// the same way notmuch's make_boolean_term does: terms containing
+// whitespace, control characters, ')', '"' or non-ASCII bytes are wrapped in
+// double quotes, with any double quotes inside doubled.
+func booleanTerm(prefix, term string) string {
+	if !termNeedsQuoting(term) {
+		return prefix + ":" + term
+	}
+	return prefix + `:"` + strings.Replace(term, `"`, `""`, -1) + `"`
+}
+
+func termNeedsQuoting(term string) bool {
+	if term == "" {
+		return true
+	}
+	for i := 0; i < len(term); i++ {
+		c := term[i]
+		if c <= ' ' || c == ')' || c == '"' || c > 127 {
+			return true
+		}
+	}
+	return false
+}
@@ -22,6 +22,11 @@ func (t *Thread) toC() *C.notmuch_thread_t {
 	return (*C.notmuch_thread_t)(t.cptr)
 }
 
+// db returns the database the thread was retrieved from.
+func (t *Thread) db() *DB {
+	return (*DB)((*cStruct)(t).root())
+}
+
 func (t *Thread) Close() error {
 	return (*cStruct)(t).doClose(func() error {
 		C.notmuch_thread_destroy(t.toC())
@@ -80,8 +85,7 @@ func (t *Thread) Messages() *Messages {
 func (t *Thread) Authors() ([]string, []string) {
 	var matched, unmatched []string
 
-	as := C.GoString(C.notmuch_thread_get_authors(t.toC()))
-	munm := strings.Split(as, "|")
+	munm := strings.Split(t.authors(), "|")
 	if len(munm) > 1 {
 		matched = strings.Split(munm[0], ",")
 		unmatched = strings.Split(munm[1], ",")
@@ -97,6 +101,13 @@ func (t *Thread) Authors() ([]string, []string) {
 	return matched, unmatched
 }
 
+// authors returns the authors of the thread in the format used by notmuch:
+// matched authors separated by commas, followed by a '|' and the unmatched
+// authors if there are any.
+func (t *Thread) authors() string {
+	return C.GoString(C.notmuch_thread_get_authors(t.toC()))
+}
+
 // OldestDate returns the date of the oldest message in the thread.
 func (t *Thread) OldestDate() time.Time {
 	ctime := C.notmuch_thread_get_oldest_date(t.toC())